@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/clouway/go-epay/pkg/server/db"
+)
+
+var (
+	projectID = flag.String("project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "the id of the project which datastore is keeping the environments")
+	dryRun    = flag.Bool("dry-run", false, "only report environments which are stored in the legacy layout")
+)
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	dClient, err := datastore.NewClient(ctx, *projectID)
+	if err != nil {
+		log.Fatalf("could not create datastore client due: %v", err)
+	}
+
+	migrations, err := db.MigrateEnvironments(ctx, dClient, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	legacy, migrated := 0, 0
+	for _, m := range migrations {
+		if m.Err != nil {
+			log.Printf("%s: could not be migrated due: %v", m.Name, m.Err)
+			continue
+		}
+		if !m.Legacy {
+			log.Printf("%s: already in the current layout", m.Name)
+			continue
+		}
+		legacy++
+
+		if len(m.Conflicts) > 0 {
+			log.Printf("%s: legacy properties %s are conflicting and the current values are kept", m.Name, strings.Join(m.Conflicts, ", "))
+		}
+		if len(m.Dropped) > 0 {
+			log.Printf("%s: unknown properties %s are dropped", m.Name, strings.Join(m.Dropped, ", "))
+		}
+
+		if m.Migrated {
+			migrated++
+			log.Printf("%s: migrated", m.Name)
+		} else {
+			log.Printf("%s: will be migrated", m.Name)
+		}
+	}
+
+	log.Printf("migrated %d of %d legacy environments (%d environments in total)", migrated, legacy, len(migrations))
+}
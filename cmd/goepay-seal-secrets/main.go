@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/secrets"
+	"github.com/clouway/go-epay/pkg/server/db"
+)
+
+var (
+	projectID = flag.String("project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "the id of the project which datastore is keeping the environments")
+	keyFile   = flag.String("key-file", "", "the path to the base64 encoded AES-256 key file")
+	kmsKey    = flag.String("kms-key", "", "the resource name of the Cloud KMS key")
+	dryRun    = flag.Bool("dry-run", false, "only report environments which are having plaintext secrets")
+)
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	km, err := secrets.NewKeyManager(ctx, *keyFile, *kmsKey)
+	if err != nil {
+		log.Fatalf("could not create key manager due: %v", err)
+	}
+	if km == nil {
+		log.Fatalf("key-file or kms-key should be provided")
+	}
+	envelope := secrets.NewEnvelope(km)
+
+	dClient, err := datastore.NewClient(ctx, *projectID)
+	if err != nil {
+		log.Fatalf("could not create datastore client due: %v", err)
+	}
+	store := db.NewEnvironmentStore(dClient)
+
+	names, err := store.List(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sealed := 0
+	for _, name := range names {
+		// The environment is read and written in a single transaction, so changes which are made
+		// concurrently, e.g by the admin API, are not overwritten with the stale environment.
+		var (
+			result    string
+			encrypted bool
+		)
+		err := db.UpdateEnvironment(ctx, dClient, name, func(env *epay.Environment) (bool, error) {
+			encrypted = false
+			if !secrets.HasPlaintextSecrets(env) {
+				result = "secrets are already encrypted"
+				return false, nil
+			}
+			if *dryRun {
+				result = "secrets will be encrypted"
+				return false, nil
+			}
+			if err := envelope.SealEnvironment(ctx, env); err != nil {
+				return false, fmt.Errorf("could not encrypt secrets due: %v", err)
+			}
+			result = "secrets were encrypted"
+			encrypted = true
+			return true, nil
+		})
+		if err != nil {
+			log.Printf("%s: skipped due: %v", name, err)
+			continue
+		}
+		if encrypted {
+			sealed++
+		}
+		log.Printf("%s: %s", name, result)
+	}
+
+	log.Printf("encrypted secrets of %d of %d environments", sealed, len(names))
+}
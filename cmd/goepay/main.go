@@ -2,23 +2,70 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/andyfusniak/stackdriver-gae-logrus-plugin"
 	lmiddleware "github.com/andyfusniak/stackdriver-gae-logrus-plugin/middleware"
 	"github.com/clouway/go-epay/pkg/client"
+	"github.com/clouway/go-epay/pkg/client/breaker"
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/secrets"
+	"github.com/clouway/go-epay/pkg/server"
+	"github.com/clouway/go-epay/pkg/server/admin"
 	"github.com/clouway/go-epay/pkg/server/api"
 	"github.com/clouway/go-epay/pkg/server/db"
+	"github.com/clouway/go-epay/pkg/server/envcache"
+	"github.com/clouway/go-epay/pkg/server/envstore"
 	"github.com/clouway/go-epay/pkg/server/middleware"
+	"github.com/clouway/go-epay/pkg/server/tenant"
 
 	"cloud.google.com/go/datastore"
 	"github.com/gorilla/mux"
+	_ "github.com/lib/pq"
 
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// replayTTL is the time for which processed requests are remembered
+	replayTTL = 48 * time.Hour
+	// maxRequestSkew is the maximum allowed skew of the request timestamp, when it's provided
+	maxRequestSkew = 15 * time.Minute
+	// envCacheTTL is the time for which environments are cached
+	envCacheTTL = 5 * time.Minute
+	// envNegativeCacheTTL is the time for which unknown environments are cached
+	envNegativeCacheTTL = time.Minute
+	// tenantPathPrefix is the path prefix of the routes which are carrying the tenant
+	tenantPathPrefix = "/t/"
+	// defaultTenantResolvers is the default chain of tenant resolvers. The header and the appengine
+	// resolvers are not part of it, as their headers could be set by any client which reaches the service.
+	defaultTenantResolvers = "path,host"
+	// appEngineTenantResolvers is the default chain of tenant resolvers on App Engine, which sets
+	// the metadata header of the custom domains
+	appEngineTenantResolvers = "path,appengine,host"
+	// defaultHostAliases are the default aliases of the hosts which are resolved by the host resolver
+	defaultHostAliases = "appspot.com=default"
+	// envFileWatchInterval is the interval on which the environments file is checked for changes
+	envFileWatchInterval = 10 * time.Second
+)
+
+var (
+	envStoreKind = flag.String("env-store", envOrDefault("ENV_STORE", "datastore"), "the store of environments: datastore, file, env or sql")
+	envFile      = flag.String("env-file", os.Getenv("ENV_FILE"), "the YAML or JSON file with environments when file store is used")
+	sqlDriver    = flag.String("sql-driver", envOrDefault("SQL_DRIVER", "sqlite3"), "the SQL driver when sql store is used: sqlite3 or postgres")
+	sqlDSN       = flag.String("sql-dsn", os.Getenv("SQL_DSN"), "the data source name of the database when sql store is used")
+	validateOnly = flag.Bool("validate", false, "validates the configuration of all environments and exits")
+)
+
 func main() {
+	flag.Parse()
+
 	ctx := context.Background()
 	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
 
@@ -36,26 +83,113 @@ func main() {
 	// Log the debug severity or above.
 	log.SetLevel(log.DebugLevel)
 
-	dClient, err := datastore.NewClient(ctx, projectID)
+	// Datastore is required only when it's used as environment store or a project is configured
+	var dClient *datastore.Client
+	if *envStoreKind == "datastore" || projectID != "" {
+		c, err := datastore.NewClient(ctx, projectID)
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		dClient = c
+	} else {
+		log.Warnf("Datastore is not configured, so processed requests are kept in memory and UCRM billings are answered with a common error")
+	}
+
+	rawStore, err := environmentStore(ctx, dClient)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		log.Fatalf("Failed to create environment store: %v", err)
 	}
+	registry, _ := rawStore.(epay.EnvironmentRegistry)
 
-	envStore := db.NewEnvironmentStore(dClient)
-	cf := client.NewClientFactory(dClient)
+	km, err := secrets.NewKeyManager(ctx, os.Getenv("SECRETS_KEY_FILE"), os.Getenv("SECRETS_KMS_KEY"))
+	if err != nil {
+		log.Fatalf("Failed to create key manager: %v", err)
+	}
+	dbStore := rawStore
+	var envelope *secrets.Envelope
+	if km != nil {
+		envelope = secrets.NewEnvelope(km)
+		dbStore = secrets.NewDecryptingStore(dbStore, envelope)
+	}
+
+	if registry != nil {
+		invalid, err := validateEnvironments(ctx, registry, dbStore)
+		if err != nil {
+			log.Errorf("Failed to validate environments: %v", err)
+		}
+		if *validateOnly {
+			if err != nil {
+				os.Exit(1)
+			}
+			if invalid > 0 {
+				log.Fatalf("%d environments are not valid", invalid)
+			}
+			log.Printf("all environments are valid")
+			return
+		}
+	}
+
+	envStore := envcache.New(epay.NewValidatingStore(dbStore), envcache.Options{
+		TTL:         envCacheTTL,
+		NegativeTTL: envNegativeCacheTTL,
+	})
+	breakers := breaker.NewRegistry()
+	cf := client.NewClientFactoryWithBreakers(dClient, breakers, server.TenantFromContext)
 
 	r := mux.NewRouter()
 
-	skipChecks := middleware.Skip("IDN", map[string]interface{}{
-		"1111111111": true,
+	aliases, err := hostAliases(envOrDefault("TENANT_HOST_ALIASES", defaultHostAliases))
+	if err != nil {
+		log.Fatalf("Failed to create tenant resolver: %v", err)
+	}
+	resolver, err := tenantResolver(os.Getenv("TENANT_RESOLVERS"), aliases, os.Getenv("GAE_APPLICATION") != "")
+	if err != nil {
+		log.Fatalf("Failed to create tenant resolver: %v", err)
+	}
+
+	epayAPI := middleware.EpayAPIMiddleware(envStore, resolver)
+	var replayStore server.ReplayStore = server.NewMemoryReplayStore()
+	if dClient != nil {
+		replayStore = db.NewReplayStore(dClient)
+	}
+	replayGuard := middleware.ReplayGuard(replayStore, middleware.ReplayOptions{
+		TTL:            replayTTL,
+		TimestampParam: "TIMESTAMP",
+		MaxSkew:        maxRequestSkew,
 	})
-	epayAPI := middleware.EpayAPIMiddleware(envStore)
+	epayHandler := func(op epay.Operation, h http.Handler) http.Handler {
+		return epayAPI(replayGuard(middleware.IDNRules(op)(h)))
+	}
+
+	// Routes are available also with a tenant prefix, e.g /t/{tenant}/v1/pay/init
+	for _, router := range []*mux.Router{r, r.PathPrefix(tenantPathPrefix + "{tenant}").Subrouter()} {
+		router.Handle("/v1/pay/init", epayHandler(epay.OperationCheck, api.CheckBill(cf))).Methods("GET", "POST").MatcherFunc(epayType("CHECK"))
+		router.Handle("/v1/pay/init", epayHandler(epay.OperationInit, api.CreatePaymentOrder(cf))).Methods("GET", "POST").MatcherFunc(epayType("BILLING"))
+		router.Handle("/v1/pay/confirm", epayHandler(epay.OperationConfirm, api.ConfirmPaymentOrder(cf))).Methods("GET", "POST").MatcherFunc(epayType("BILLING"))
+	}
+
+	r.Handle("/healthz", admin.Health(breakers)).Methods("GET")
 
-	r.Handle("/v1/pay/init", skipChecks(epayAPI(api.CheckBill(cf)))).Queries("TYPE", "CHECK")
-	r.Handle("/v1/pay/init", epayAPI(api.CreatePaymentOrder(cf))).Queries("TYPE", "BILLING")
-	r.Handle("/v1/pay/confirm", epayAPI(api.ConfirmPaymentOrder(cf))).Queries("TYPE", "BILLING")
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		adminAPI := middleware.AdminAuth(token)
+		r.Handle("/admin/v1/cache/invalidate", adminAPI(admin.InvalidateCache(envStore))).Methods("POST")
+		r.Handle("/admin/v1/cache/stats", adminAPI(admin.CacheStats(envStore))).Methods("GET")
+		r.Handle("/admin/v1/breakers", adminAPI(admin.Breakers(breakers))).Methods("GET")
+		r.Handle("/admin/v1/environments/{tenant}/validate", adminAPI(admin.ValidateEnvironment(dbStore))).Methods("POST")
+		if registry != nil {
+			var sealer admin.Sealer
+			if envelope != nil {
+				sealer = envelope
+			}
+			r.Handle("/admin/v1/environments/{tenant}", adminAPI(admin.PutEnvironment(registry, sealer, envStore))).Methods("PUT")
+		}
+	}
 
-	http.Handle("/", lmiddleware.XCloudTraceContext(r))
+	// ePay expects STATUS in the response of any request
+	r.NotFoundHandler = api.NotSupported()
+	r.MethodNotAllowedHandler = api.NotSupported()
+
+	http.Handle("/", lmiddleware.XCloudTraceContext(middleware.Recover(r)))
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -68,3 +202,116 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// environmentStore creates the store of environments which is selected by the -env-store flag.
+func environmentStore(ctx context.Context, dClient *datastore.Client) (epay.EnvironmentStore, error) {
+	switch *envStoreKind {
+	case "datastore":
+		return db.NewEnvironmentStore(dClient), nil
+	case "file":
+		fs, err := envstore.NewFileStore(*envFile)
+		if err != nil {
+			return nil, err
+		}
+		go fs.Watch(ctx, envFileWatchInterval)
+		return fs, nil
+	case "env":
+		return envstore.NewEnvVarStore("EPAY_")
+	case "sql":
+		sqlDB, err := sql.Open(*sqlDriver, *sqlDSN)
+		if err != nil {
+			return nil, fmt.Errorf("could not open database due: %v", err)
+		}
+		if _, err := sqlDB.ExecContext(ctx, envstore.Schema); err != nil {
+			return nil, fmt.Errorf("could not create environments table due: %v", err)
+		}
+		return envstore.NewSQLStore(sqlDB, *sqlDriver), nil
+	default:
+		return nil, fmt.Errorf("unknown environment store '%s'", *envStoreKind)
+	}
+}
+
+// validateEnvironments validates the configuration of all environments of the registry and
+// returns the number of the environments which are not valid.
+func validateEnvironments(ctx context.Context, registry epay.EnvironmentRegistry, store epay.EnvironmentStore) (int, error) {
+	names, err := registry.List(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not list environments due: %v", err)
+	}
+
+	invalid := 0
+	for _, name := range names {
+		env, err := store.Get(ctx, name)
+		if err == nil {
+			err = env.Validate()
+		}
+		if err != nil {
+			log.Errorf("environment '%s' is not valid: %v", name, err)
+			invalid++
+		}
+	}
+	return invalid, nil
+}
+
+func envOrDefault(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
+
+// epayType matches ePay requests of the provided TYPE which is received either
+// in the query string or in the form-encoded body.
+func epayType(t string) mux.MatcherFunc {
+	return func(r *http.Request, rm *mux.RouteMatch) bool {
+		req, err := api.ParseRequest(r)
+		return err == nil && req.Type == t
+	}
+}
+
+// tenantResolver creates a chain of tenant resolvers from the provided comma separated
+// list of resolver names. The host resolver maps the hosts with the provided aliases and
+// the default chain includes the appengine resolver only when running on App Engine.
+func tenantResolver(names string, aliases map[string]string, onAppEngine bool) (tenant.Resolver, error) {
+	if names == "" {
+		names = defaultTenantResolvers
+		if onAppEngine {
+			names = appEngineTenantResolvers
+		}
+	}
+
+	var resolvers []tenant.Resolver
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "path":
+			resolvers = append(resolvers, tenant.ByPathPrefix(tenantPathPrefix))
+		case "header":
+			resolvers = append(resolvers, tenant.ByHeader("X-Epay-Tenant"))
+		case "merchant":
+			resolvers = append(resolvers, tenant.ByParam("MERCHANTID"))
+		case "appengine":
+			resolvers = append(resolvers, tenant.ByAppEngineMetadata())
+		case "host":
+			resolvers = append(resolvers, tenant.ByHost(aliases))
+		case "default":
+			resolvers = append(resolvers, tenant.Static("default"))
+		default:
+			return nil, fmt.Errorf("unknown tenant resolver '%s'", name)
+		}
+	}
+	return tenant.Chain(resolvers...), nil
+}
+
+// hostAliases parses the comma separated list of host aliases, e.g appspot.com=default, where
+// each of them maps a host or a host suffix to tenant.
+func hostAliases(value string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	for _, alias := range epay.SplitList(value) {
+		kv := strings.SplitN(alias, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("host alias '%s' is not in the host=tenant format", alias)
+		}
+		aliases[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return aliases, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+func runChecksum(args []string) error {
+	fs := newFlagSet("checksum", "KEY=VALUE...")
+	secret := fs.String("secret", os.Getenv("EPAY_SECRET"), "the secret provided by ePay")
+	fs.Parse(args)
+
+	if *secret == "" {
+		return fmt.Errorf("secret is required")
+	}
+	params, err := parseParams(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(epay.Checksum(params, *secret))
+	return nil
+}
+
+func runVerify(args []string) error {
+	fs := newFlagSet("verify", "KEY=VALUE...")
+	secret := fs.String("secret", os.Getenv("EPAY_SECRET"), "the secret provided by ePay")
+	checksum := fs.String("checksum", "", "the checksum to verify, the CHECKSUM parameter is used when it's not provided")
+	fs.Parse(args)
+
+	if *secret == "" {
+		return fmt.Errorf("secret is required")
+	}
+	params, err := parseParams(fs.Args())
+	if err != nil {
+		return err
+	}
+	if *checksum == "" {
+		*checksum = params.Get("CHECKSUM")
+	}
+	if *checksum == "" {
+		return fmt.Errorf("checksum is required")
+	}
+
+	if epay.VerifyChecksum(params, *checksum, []epay.Secret{{Value: *secret}}) == nil {
+		return fmt.Errorf("checksum is not valid, expected: %s", epay.Checksum(params, *secret))
+	}
+	fmt.Println("checksum is valid")
+	return nil
+}
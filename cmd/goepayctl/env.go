@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"cloud.google.com/go/datastore"
+	_ "github.com/lib/pq"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/secrets"
+	"github.com/clouway/go-epay/pkg/server/db"
+	"github.com/clouway/go-epay/pkg/server/envstore"
+)
+
+// effectiveConfig is the effective configuration of a tenant.
+type effectiveConfig struct {
+	Tenant      string             `json:"tenant"`
+	Backends    []string           `json:"backends"`
+	Valid       bool               `json:"valid"`
+	Error       string             `json:"error,omitempty"`
+	Environment *envstore.Document `json:"environment"`
+}
+
+type storeFlags struct {
+	store     *string
+	projectID *string
+	envFile   *string
+	sqlDriver *string
+	sqlDSN    *string
+	keyFile   *string
+	kmsKey    *string
+}
+
+func newStoreFlags(fs *flag.FlagSet) *storeFlags {
+	return &storeFlags{
+		store:     fs.String("store", "datastore", "the store of environments: datastore, sql or file"),
+		projectID: fs.String("project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "the id of the project which datastore is keeping the environments"),
+		envFile:   fs.String("env-file", "", "the YAML or JSON file with environments when file store is used"),
+		sqlDriver: fs.String("sql-driver", "sqlite3", "the SQL driver when sql store is used: sqlite3 or postgres"),
+		sqlDSN:    fs.String("sql-dsn", "", "the data source name of the database when sql store is used"),
+		keyFile:   fs.String("key-file", "", "the path to the base64 encoded AES-256 key file used for the secrets"),
+		kmsKey:    fs.String("kms-key", "", "the resource name of the Cloud KMS key used for the secrets"),
+	}
+}
+
+func (f *storeFlags) open(ctx context.Context) (epay.EnvironmentStore, *secrets.Envelope, error) {
+	var store epay.EnvironmentStore
+	switch *f.store {
+	case "datastore":
+		dClient, err := datastore.NewClient(ctx, *f.projectID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create datastore client due: %v", err)
+		}
+		store = db.NewEnvironmentStore(dClient)
+	case "sql":
+		sqlDB, err := sql.Open(*f.sqlDriver, *f.sqlDSN)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open database due: %v", err)
+		}
+		if _, err := sqlDB.ExecContext(ctx, envstore.Schema); err != nil {
+			return nil, nil, fmt.Errorf("could not create environments table due: %v", err)
+		}
+		store = envstore.NewSQLStore(sqlDB, *f.sqlDriver)
+	case "file":
+		fs, err := envstore.NewFileStore(*f.envFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		store = fs
+	default:
+		return nil, nil, fmt.Errorf("unknown environment store '%s'", *f.store)
+	}
+
+	km, err := secrets.NewKeyManager(ctx, *f.keyFile, *f.kmsKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create key manager due: %v", err)
+	}
+	if km == nil {
+		return store, nil, nil
+	}
+	return store, secrets.NewEnvelope(km), nil
+}
+
+func runEnv(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("subcommand is required: list, get, put or show")
+	}
+	ctx := context.Background()
+
+	sub := args[0]
+	fs := newFlagSet("env "+sub, "")
+	sf := newStoreFlags(fs)
+	switch sub {
+	case "list":
+		fs.Usage = usageOf(fs, "env list [flags]")
+	case "get", "show":
+		fs.Usage = usageOf(fs, "env "+sub+" [flags] TENANT")
+	case "put":
+		fs.Usage = usageOf(fs, "env put [flags] TENANT FILE.json")
+	default:
+		return fmt.Errorf("unknown subcommand '%s'", sub)
+	}
+	fs.Parse(args[1:])
+
+	store, envelope, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "list":
+		registry, ok := store.(epay.EnvironmentRegistry)
+		if !ok {
+			return fmt.Errorf("environments of the %s store could not be listed", *sf.store)
+		}
+		names, err := registry.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+
+	case "get":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		env, err := store.Get(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return printJSON(envstore.NewDocument(secrets.Redact(env)))
+
+	case "show":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		return showEnvironment(ctx, store, envelope, fs.Arg(0))
+
+	default:
+		if fs.NArg() != 2 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		return putEnvironment(ctx, store, envelope, fs.Arg(0), fs.Arg(1))
+	}
+}
+
+// showEnvironment prints the effective configuration of the tenant with redacted secrets.
+func showEnvironment(ctx context.Context, store epay.EnvironmentStore, envelope *secrets.Envelope, name string) error {
+	if envelope != nil {
+		store = secrets.NewDecryptingStore(store, envelope)
+	}
+	env, err := store.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	c := &effectiveConfig{Tenant: name, Valid: true, Environment: envstore.NewDocument(secrets.Redact(env))}
+	if env.BillingURL != "" {
+		c.Backends = append(c.Backends, "telcong")
+	}
+	if _, ok := env.Metadata[epay.MetadataBillingURL]; ok {
+		c.Backends = append(c.Backends, "ucrm")
+	}
+	if err := env.Validate(); err != nil {
+		c.Valid = false
+		c.Error = err.Error()
+	}
+	return printJSON(c)
+}
+
+// putEnvironment validates and stores the environment from the provided JSON file.
+func putEnvironment(ctx context.Context, store epay.EnvironmentStore, envelope *secrets.Envelope, name, file string) error {
+	registry, ok := store.(epay.EnvironmentRegistry)
+	if !ok {
+		return fmt.Errorf("environments could not be stored in this store")
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	doc := &envstore.Document{}
+	if err := json.Unmarshal(content, doc); err != nil {
+		return fmt.Errorf("could not decode environment due: %v", err)
+	}
+	env, err := doc.Environment(name)
+	if err != nil {
+		return err
+	}
+	if err := env.Validate(); err != nil {
+		return err
+	}
+
+	if envelope != nil {
+		if err := envelope.SealEnvironment(ctx, env); err != nil {
+			return fmt.Errorf("could not encrypt secrets due: %v", err)
+		}
+	} else if secrets.HasPlaintextSecrets(env) {
+		fmt.Fprintln(os.Stderr, "warning: secrets are stored as plaintext as no key-file or kms-key is provided")
+	}
+
+	if err := registry.Put(ctx, name, env); err != nil {
+		return err
+	}
+	fmt.Printf("environment '%s' was stored\n", name)
+	return nil
+}
+
+func usageOf(fs *flag.FlagSet, usage string) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "Usage: goepayctl %s\n\nFlags:\n", usage)
+		fs.PrintDefaults()
+	}
+}
+
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
@@ -0,0 +1,88 @@
+// Command goepayctl is a tool for operators of goepay which computes checksums, sends
+// signed requests to goepay and the TCP adapter and manages the environments.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"checksum", "computes the checksum of the provided parameters", runChecksum},
+	{"verify", "verifies the checksum of the provided parameters", runVerify},
+	{"request", "sends a signed CHECK, INIT or CONFIRM request to goepay", runRequest},
+	{"tcp", "sends a QBN or QBC request to the TCP adapter", runTCP},
+	{"env", "manages the environments: list, get, put or show", runEnv},
+	{"orders", "reports or removes the unpaid UCRM payment orders", runOrders},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "goepayctl %s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: goepayctl <command> [flags] [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nUse \"goepayctl <command> -h\" for the flags of the command.\n")
+}
+
+// newFlagSet creates a new set of flags of the command with the provided name.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: goepayctl %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseParams parses parameters provided as KEY=VALUE arguments or as a single query string.
+func parseParams(args []string) (url.Values, error) {
+	params := make(url.Values)
+	for _, arg := range args {
+		if strings.Contains(arg, "&") {
+			q, err := url.ParseQuery(arg)
+			if err != nil {
+				return nil, fmt.Errorf("bad query '%s': %v", arg, err)
+			}
+			for k, vs := range q {
+				params[k] = append(params[k], vs...)
+			}
+			continue
+		}
+
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("parameter '%s' is not in KEY=VALUE format", arg)
+		}
+		params.Add(parts[0], parts[1])
+	}
+	return params, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/clouway/go-epay/pkg/client/ucrm"
+)
+
+func runOrders(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("subcommand is required: report or cleanup")
+	}
+	ctx := context.Background()
+
+	sub := args[0]
+	fs := newFlagSet("orders "+sub, "")
+	projectID := fs.String("project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "the id of the project which datastore is keeping the payment orders")
+	olderThan := fs.Duration("older-than", 72*time.Hour, "the minimal age of the listed or removed orders")
+	archive := fs.Bool("archive", false, "move the removed orders to the archive instead of deleting them")
+	switch sub {
+	case "report", "cleanup":
+		fs.Usage = usageOf(fs, "orders "+sub+" [flags]")
+	default:
+		return fmt.Errorf("unknown subcommand '%s'", sub)
+	}
+	fs.Parse(args[1:])
+
+	dClient, err := datastore.NewClient(ctx, *projectID)
+	if err != nil {
+		return fmt.Errorf("could not create datastore client due: %v", err)
+	}
+	before := time.Now().Add(-*olderThan)
+
+	if sub == "report" {
+		orders, err := ucrm.ListUnpaidOrders(ctx, dClient, before)
+		if err != nil {
+			return err
+		}
+		return printJSON(orders)
+	}
+
+	removed, err := ucrm.CleanupOrders(ctx, dClient, before, *archive)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d unpaid payment orders were removed\n", removed)
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// operations maps the operations to the path and the TYPE of the request.
+var operations = map[epay.Operation]struct {
+	path string
+	typ  string
+}{
+	epay.OperationCheck:   {"/v1/pay/init", "CHECK"},
+	epay.OperationInit:    {"/v1/pay/init", "BILLING"},
+	epay.OperationConfirm: {"/v1/pay/confirm", "BILLING"},
+}
+
+func runRequest(args []string) error {
+	fs := newFlagSet("request", "[KEY=VALUE...]")
+	baseURL := fs.String("url", "http://localhost:8080", "the url of the goepay instance")
+	tenant := fs.String("tenant", "", "the tenant which is sent as path prefix")
+	secret := fs.String("secret", os.Getenv("EPAY_SECRET"), "the secret provided by ePay")
+	op := fs.String("op", "CHECK", "the operation: CHECK, INIT or CONFIRM")
+	idn := fs.String("idn", "", "the IDN of the subscriber")
+	tid := fs.String("tid", "", "the id of the transaction, generated when it's not provided")
+	merchantID := fs.String("merchant", "", "the id of the merchant")
+	amount := fs.Int("amount", 0, "the amount in coins which is sent when it's positive")
+	post := fs.Bool("post", false, "send the request as POST form instead of GET")
+	fs.Parse(args)
+
+	o, ok := operations[epay.Operation(strings.ToUpper(*op))]
+	if !ok {
+		return fmt.Errorf("unknown operation '%s'", *op)
+	}
+	if *secret == "" {
+		return fmt.Errorf("secret is required")
+	}
+
+	params, err := parseParams(fs.Args())
+	if err != nil {
+		return err
+	}
+	params.Set("TYPE", o.typ)
+	params.Set("IDN", *idn)
+	if *tid == "" {
+		*tid = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	params.Set("TID", *tid)
+	if *merchantID != "" {
+		params.Set("MERCHANTID", *merchantID)
+	}
+	if *amount > 0 {
+		params.Set("AMOUNT", strconv.Itoa(*amount))
+	}
+	params.Set("CHECKSUM", epay.Checksum(params, *secret))
+
+	target := strings.TrimSuffix(*baseURL, "/")
+	if *tenant != "" {
+		target += "/t/" + url.PathEscape(*tenant)
+	}
+	target += o.path
+
+	var resp *http.Response
+	if *post {
+		resp, err = http.PostForm(target, params)
+	} else {
+		resp, err = http.Get(target + "?" + params.Encode())
+	}
+	if err != nil {
+		return fmt.Errorf("could not send request due: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response due: %v", err)
+	}
+	fmt.Printf("%s\n%s\n", resp.Status, body)
+	return nil
+}
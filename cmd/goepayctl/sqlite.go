@@ -0,0 +1,8 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+// The SQLite driver requires cgo, so it's registered only when goepayctl is built with cgo, while
+// builds with CGO_ENABLED=0 support only the postgres driver of the sql store.
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+func runTCP(args []string) error {
+	fs := newFlagSet("tcp", "")
+	addr := fs.String("addr", "localhost:5555", "the address of the TCP adapter")
+	typ := fs.String("type", "QBN", "the type of the request: QBN or QBC")
+	idn := fs.String("idn", "", "the IDN of the subscriber")
+	tid := fs.String("tid", "", "the id of the transaction")
+	amount := fs.Int("amount", 0, "the amount in coins of the QBC request")
+	timeout := fs.Duration("timeout", 10*time.Second, "the timeout of the request")
+	fs.Parse(args)
+
+	var cmd string
+	switch strings.ToUpper(*typ) {
+	case "QBN":
+		cmd = fmt.Sprintf("XTYPE=QBN\nIDN=%s\nTID=%s\n", *idn, *tid)
+	case "QBC":
+		cmd = fmt.Sprintf("XTYPE=QBC\nIDN=%s\nTID=%s\nAMOUNT=%d\n", *idn, *tid, *amount)
+	default:
+		return fmt.Errorf("unknown type '%s'", *typ)
+	}
+
+	c, err := net.DialTimeout("tcp", *addr, *timeout)
+	if err != nil {
+		return fmt.Errorf("could not connect to '%s' due: %v", *addr, err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(*timeout))
+
+	if _, err := c.Write([]byte(cmd)); err != nil {
+		return fmt.Errorf("could not send request due: %v", err)
+	}
+	if tcp, ok := c.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+
+	resp, err := ioutil.ReadAll(c)
+	if err != nil {
+		return fmt.Errorf("could not read response due: %v", err)
+	}
+	fmt.Print(string(resp))
+	return nil
+}
@@ -23,6 +23,8 @@ var (
 	listenAddr     = flag.String("listenAddr", ":5555", "the tcp listenAddr of the epay-adapter server")
 	billingKeyFile = flag.String("billing-key-file", "app.key", "the path to the billing API keyfile")
 	billingURL     = flag.String("billing-url", "https://cloud.telcong.com", "the url of the billing server")
+	nameMasking    = flag.String("name-masking", "", "the masking of customer names in bill descriptions: none, full, initials or firstname. Descriptions are not sent when it's not set")
+	idnMasking     = flag.Bool("idn-masking", false, "whether to mask IDN in bill descriptions")
 )
 
 const (
@@ -33,6 +35,12 @@ const (
 func main() {
 	flag.Parse()
 
+	switch epay.NameMasking(*nameMasking) {
+	case "", epay.NameMaskingNone, epay.NameMaskingFull, epay.NameMaskingInitials, epay.NameMaskingFirstName:
+	default:
+		log.Fatalf("name-masking '%s' is not one of none, full, initials or firstname", *nameMasking)
+	}
+
 	conf, err := loadConf(*billingKeyFile)
 	if err != nil {
 		log.Fatalf("could not load billing-key-file '%s' due: %v", *billingKeyFile, err)
@@ -52,7 +60,8 @@ func main() {
 	client := telcong.NewClient(oauth2client, telcongURL)
 
 	server := epay.NewServer()
-	go server.Serve(l, &telcongEpayGateway{client})
+	env := &epay.Environment{NameMasking: epay.NameMasking(*nameMasking), IDNMasking: *idnMasking}
+	go server.Serve(l, &telcongEpayGateway{client, env})
 
 	sigs := make(chan os.Signal, 1)
 	done := make(chan bool, 1)
@@ -76,20 +85,14 @@ func main() {
 
 type telcongEpayGateway struct {
 	client epay.Client
+	env    *epay.Environment
 }
 
 // GetCurrentBill returns the current bill of the provided customer.
 func (t *telcongEpayGateway) GetCurrentBill(customerID, transactionID string) (*epay.BillResponse, error) {
 	res, err := t.client.CreatePaymentOrder(context.Background(), epay.CreatePaymentOrderRequest{SubscriberID: customerID, TransactionID: transactionID, PaymentSource: EPAY})
 	if err != nil {
-		if err == epay.ErrPaymentOrderAlreadyExists {
-			return nil, fmt.Errorf("bill with transactionId '%s' was already processed", transactionID)
-		}
-
-		if err == epay.ErrSubscriberNotFound {
-			return &epay.BillResponse{Successful: false, UnknownSubscriber: true}, nil
-		}
-
+		// the error is mapped to the status of the response by the server
 		return nil, err
 	}
 	// Convert currency to be applicable with the epay adapter as it
@@ -97,7 +100,13 @@ func (t *telcongEpayGateway) GetCurrentBill(customerID, transactionID string) (*
 	amount, _ := strconv.ParseFloat(res.Amount.Value, 64)
 	inCoins := int(amount * 100)
 
-	return &epay.BillResponse{Successful: true, Amount: inCoins}, nil
+	bill := &epay.BillResponse{Successful: true, Amount: inCoins}
+	// the descriptions carry personal data, so they are sent only when their masking is configured
+	if t.env.NameMasking != "" {
+		bill.ShortDesc = "Абонатен номер: " + t.env.DisplayIDN(customerID)
+		bill.LongDesc = "Клиент: " + t.env.DisplayName(res.CustomerName)
+	}
+	return bill, nil
 }
 
 // PayBill pays bill using the provided amount
@@ -105,17 +114,11 @@ func (t *telcongEpayGateway) PayBill(customerID, transactionID string, Amount in
 	paymentOrder, err := t.client.GetPaymentOrder(context.Background(), transactionID)
 
 	if err != nil {
-		if err == epay.ErrPaymentOrderNotFound {
-			return &epay.PaymentResponse{Successful: false}, nil
-		}
-		return nil, fmt.Errorf("could not retrieve payment order due: %v", err)
+		return nil, fmt.Errorf("could not retrieve payment order due: %w", err)
 	}
 
 	_, err = t.client.PayPaymentOrder(context.Background(), paymentOrder.ID)
 	if err != nil {
-		if err == epay.ErrPaymentOrderAlreadyPaid {
-			return &epay.PaymentResponse{Successful: false, AlreadyPaid: true}, nil
-		}
 		return nil, err
 	}
 	return &epay.PaymentResponse{Successful: true}, nil
@@ -8,7 +8,11 @@ require (
 	github.com/google/go-cmp v0.3.0
 	github.com/googleapis/google-cloud-go-testing v0.0.0-20191008195207-8e1d251e947d
 	github.com/gorilla/mux v1.7.3
+	github.com/lib/pq v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.0
 	github.com/sirupsen/logrus v1.4.2
 	golang.org/x/oauth2 v0.0.0-20191202225959-858c2ad4c8b6
+	google.golang.org/api v0.9.0
 	google.golang.org/appengine v1.6.5
+	gopkg.in/yaml.v2 v2.2.8
 )
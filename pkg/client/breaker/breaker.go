@@ -0,0 +1,156 @@
+// Package breaker provides circuit breakers of the billing backends which fail fast
+// while the backend is not available instead of waiting for it's timeouts.
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of the circuit breaker.
+type State string
+
+const (
+	// StateClosed is the state in which all requests are allowed.
+	StateClosed State = "closed"
+	// StateOpen is the state in which all requests are rejected.
+	StateOpen State = "open"
+	// StateHalfOpen is the state in which limited number of probe requests are allowed.
+	StateHalfOpen State = "half-open"
+)
+
+// Settings are the settings of the circuit breaker.
+type Settings struct {
+	// FailureThreshold is the number of consecutive failures which opens the circuit.
+	FailureThreshold int
+
+	// OpenTimeout is the time for which the circuit stays open before it's probed.
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is the number of probe requests which are allowed in half-open
+	// state. The circuit is closed when all of them are successful.
+	HalfOpenRequests int
+}
+
+// DefaultSettings are the settings used when no settings are provided for the environment.
+var DefaultSettings = Settings{
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+	HalfOpenRequests: 1,
+}
+
+// Counts are the counters of the circuit breaker.
+type Counts struct {
+	Requests            int64 `json:"requests"`
+	Successes           int64 `json:"successes"`
+	Failures            int64 `json:"failures"`
+	Rejections          int64 `json:"rejections"`
+	ConsecutiveFailures int   `json:"consecutiveFailures"`
+}
+
+// Breaker is a circuit breaker of a single backend.
+type Breaker struct {
+	mu       sync.Mutex
+	settings Settings
+	state    State
+	openedAt time.Time
+	probes   int
+	passed   int
+	counts   Counts
+	now      func() time.Time
+}
+
+// New creates a new closed circuit breaker with the provided settings.
+func New(s Settings) *Breaker {
+	return &Breaker{settings: normalize(s), state: StateClosed, now: time.Now}
+}
+
+// Allow checks whether a request is allowed. When it's allowed the returned function
+// should be called with the outcome of the request.
+func (b *Breaker) Allow() (func(success bool), bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		b.state = StateHalfOpen
+		b.probes = 0
+		b.passed = 0
+	}
+
+	switch b.state {
+	case StateOpen:
+		b.counts.Rejections++
+		return nil, false
+	case StateHalfOpen:
+		if b.probes >= b.settings.HalfOpenRequests {
+			b.counts.Rejections++
+			return nil, false
+		}
+		b.probes++
+	}
+
+	b.counts.Requests++
+	state := b.state
+	return func(success bool) { b.done(state, success) }, true
+}
+
+func (b *Breaker) done(state State, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.counts.Successes++
+		b.counts.ConsecutiveFailures = 0
+		if state == StateHalfOpen && b.state == StateHalfOpen {
+			b.passed++
+			if b.passed >= b.settings.HalfOpenRequests {
+				b.state = StateClosed
+			}
+		}
+		return
+	}
+
+	b.counts.Failures++
+	b.counts.ConsecutiveFailures++
+	if b.state == StateHalfOpen || b.counts.ConsecutiveFailures >= b.settings.FailureThreshold {
+		b.state = StateOpen
+		b.openedAt = b.now()
+	}
+}
+
+// State gets the current state of the circuit breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Counts gets the counters of the circuit breaker.
+func (b *Breaker) Counts() Counts {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.counts
+}
+
+// Update updates the settings of the circuit breaker without changing it's state.
+func (b *Breaker) Update(s Settings) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.settings = normalize(s)
+}
+
+func normalize(s Settings) Settings {
+	if s.FailureThreshold <= 0 {
+		s.FailureThreshold = DefaultSettings.FailureThreshold
+	}
+	if s.OpenTimeout <= 0 {
+		s.OpenTimeout = DefaultSettings.OpenTimeout
+	}
+	if s.HalfOpenRequests <= 0 {
+		s.HalfOpenRequests = DefaultSettings.HalfOpenRequests
+	}
+	return s
+}
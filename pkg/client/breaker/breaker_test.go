@@ -0,0 +1,144 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+func TestBreakerStates(t *testing.T) {
+	now := time.Now()
+	b := New(Settings{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	b.now = func() time.Time { return now }
+
+	fail := func() {
+		done, ok := b.Allow()
+		if !ok {
+			t.Fatalf("expected request to be allowed in state: %s", b.State())
+		}
+		done(false)
+	}
+
+	fail()
+	if got := b.State(); got != StateClosed {
+		t.Errorf("expected: %s, got: %s", StateClosed, got)
+	}
+	fail()
+	if got := b.State(); got != StateOpen {
+		t.Errorf("expected: %s, got: %s", StateOpen, got)
+	}
+	if _, ok := b.Allow(); ok {
+		t.Errorf("expected request to be rejected while circuit is open")
+	}
+
+	now = now.Add(time.Minute)
+	if got := b.State(); got != StateHalfOpen {
+		t.Errorf("expected: %s, got: %s", StateHalfOpen, got)
+	}
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatalf("expected probe request to be allowed")
+	}
+	if _, ok := b.Allow(); ok {
+		t.Errorf("expected only one probe request to be allowed")
+	}
+	probe(false)
+	if got := b.State(); got != StateOpen {
+		t.Errorf("expected failed probe to open the circuit, but got: %s", got)
+	}
+
+	now = now.Add(time.Minute)
+	probe, _ = b.Allow()
+	probe(true)
+	if got := b.State(); got != StateClosed {
+		t.Errorf("expected successful probe to close the circuit, but got: %s", got)
+	}
+
+	want := Counts{Requests: 4, Successes: 1, Failures: 3, Rejections: 2}
+	if got := b.Counts(); got != want {
+		t.Errorf("expected: %+v, got: %+v", want, got)
+	}
+}
+
+func TestClientFailures(t *testing.T) {
+	cases := []struct {
+		err     error
+		failure bool
+	}{
+		{nil, false},
+		{epay.ErrSubscriberNotFound, false},
+		{epay.ErrPaymentOrderAlreadyPaid, false},
+		{epay.ErrPaymentInProgress, false},
+		{&epay.ConfigError{Field: "billingKey", Reason: "is required"}, false},
+		{epay.ErrUnknown, true},
+		{errors.New("connection refused"), true},
+	}
+
+	for _, c := range cases {
+		b := New(Settings{FailureThreshold: 1})
+		client := NewClient(fakeClient{err: c.err}, b)
+
+		client.GetSubscriberDuties(context.Background(), "123")
+		_, err := client.GetSubscriberDuties(context.Background(), "123")
+
+		if c.failure && err != epay.ErrBackendUnavailable {
+			t.Errorf("%v: expected: %v, got: %v", c.err, epay.ErrBackendUnavailable, err)
+		}
+		if !c.failure && err != c.err {
+			t.Errorf("%v: expected error to not open the circuit, but got: %v", c.err, err)
+		}
+	}
+}
+
+func TestPanicIsCountedAsFailure(t *testing.T) {
+	now := time.Now()
+	b := New(Settings{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	b.now = func() time.Time { return now }
+	client := NewClient(fakeClient{panics: true}, b)
+
+	getDuties := func() (err error) {
+		defer func() { recover() }()
+		_, err = client.GetSubscriberDuties(context.Background(), "123")
+		return err
+	}
+
+	getDuties()
+	now = now.Add(time.Minute)
+	getDuties()
+	if got := b.State(); got != StateOpen {
+		t.Errorf("expected panicked probe to open the circuit, but got: %s", got)
+	}
+
+	// the circuit is probed again after the open timeout
+	now = now.Add(time.Minute)
+	if _, ok := b.Allow(); !ok {
+		t.Errorf("expected probe request to be allowed after panicked probe")
+	}
+}
+
+type fakeClient struct {
+	err    error
+	panics bool
+}
+
+func (f fakeClient) GetSubscriberDuties(ctx context.Context, subscriberID string) (*epay.SubscriberDuties, error) {
+	if f.panics {
+		panic("unexpected response")
+	}
+	return nil, f.err
+}
+
+func (f fakeClient) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePaymentOrderRequest) (*epay.PaymentOrder, error) {
+	return nil, f.err
+}
+
+func (f fakeClient) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.PaymentOrder, error) {
+	return nil, f.err
+}
+
+func (f fakeClient) PayPaymentOrder(ctx context.Context, orderID string) (*epay.PayPaymentOrderResponse, error) {
+	return nil, f.err
+}
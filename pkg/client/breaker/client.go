@@ -0,0 +1,93 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// NewClient creates a new client which calls the provided client through the circuit
+// breaker. Requests are rejected with epay.ErrBackendUnavailable while the circuit is open.
+func NewClient(c epay.Client, b *Breaker) epay.Client {
+	return &client{c, b}
+}
+
+type client struct {
+	client  epay.Client
+	breaker *Breaker
+}
+
+func (c *client) GetSubscriberDuties(ctx context.Context, subscriberID string) (res *epay.SubscriberDuties, err error) {
+	done, ok := c.breaker.Allow()
+	if !ok {
+		return nil, epay.ErrBackendUnavailable
+	}
+	defer finish(ctx, done, &err)
+	return c.client.GetSubscriberDuties(ctx, subscriberID)
+}
+
+func (c *client) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePaymentOrderRequest) (res *epay.PaymentOrder, err error) {
+	done, ok := c.breaker.Allow()
+	if !ok {
+		return nil, epay.ErrBackendUnavailable
+	}
+	defer finish(ctx, done, &err)
+	return c.client.CreatePaymentOrder(ctx, createReq)
+}
+
+func (c *client) GetPaymentOrder(ctx context.Context, orderKey string) (res *epay.PaymentOrder, err error) {
+	done, ok := c.breaker.Allow()
+	if !ok {
+		return nil, epay.ErrBackendUnavailable
+	}
+	defer finish(ctx, done, &err)
+	return c.client.GetPaymentOrder(ctx, orderKey)
+}
+
+func (c *client) PayPaymentOrder(ctx context.Context, orderID string) (res *epay.PayPaymentOrderResponse, err error) {
+	done, ok := c.breaker.Allow()
+	if !ok {
+		return nil, epay.ErrBackendUnavailable
+	}
+	defer finish(ctx, done, &err)
+	return c.client.PayPaymentOrder(ctx, orderID)
+}
+
+// finish reports the outcome of the call to the circuit breaker. It should be deferred, so
+// the outcome is reported also when the call panics, in which case it's counted as failure.
+func finish(ctx context.Context, done func(success bool), err *error) {
+	if r := recover(); r != nil {
+		done(false)
+		panic(r)
+	}
+	done(successful(ctx, *err))
+}
+
+// successful checks whether the backend responded to the request. Errors which are
+// responses of the backend or which are caused by the caller are not failures of the backend.
+func successful(ctx context.Context, err error) bool {
+	if err == nil || errors.Is(ctx.Err(), context.Canceled) {
+		return true
+	}
+	for _, known := range []error{
+		epay.ErrSubscriberNotFound,
+		epay.ErrPaymentOrderNotFound,
+		epay.ErrPaymentOrderAlreadyExists,
+		epay.ErrPaymentOrderAlreadyPaid,
+		epay.ErrPaymentOrderExpired,
+		epay.ErrPaymentInProgress,
+		epay.ErrSubscriberAmbiguous,
+	} {
+		if errors.Is(err, known) {
+			return true
+		}
+	}
+	var configErr *epay.ConfigError
+	if errors.As(err, &configErr) {
+		return true
+	}
+	// the backend responded, but rejected the request
+	var billingErr *epay.BillingError
+	return errors.As(err, &billingErr) && billingErr.StatusCode != 0 && !billingErr.Retryable
+}
@@ -0,0 +1,60 @@
+package breaker
+
+import (
+	"sort"
+	"sync"
+)
+
+// Status is the status of a single circuit breaker.
+type Status struct {
+	Key    string `json:"key"`
+	State  State  `json:"state"`
+	Counts Counts `json:"counts"`
+}
+
+// Registry keeps the circuit breakers of all environments and backends.
+type Registry struct {
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewRegistry creates a new empty registry of circuit breakers.
+func NewRegistry() *Registry {
+	return &Registry{breakers: make(map[string]*Breaker)}
+}
+
+// Get gets the circuit breaker with the provided key. The breaker is created when it's
+// not existing and it's settings are updated otherwise.
+func (r *Registry) Get(key string, s Settings) *Breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.breakers[key]
+	if !ok {
+		b = New(s)
+		r.breakers[key] = b
+		return b
+	}
+	b.Update(s)
+	return b
+}
+
+// Statuses gets the statuses of all circuit breakers ordered by their keys.
+func (r *Registry) Statuses() []Status {
+	r.mu.Lock()
+	keys := make([]string, 0, len(r.breakers))
+	for k := range r.breakers {
+		keys = append(keys, k)
+	}
+	r.mu.Unlock()
+	sort.Strings(keys)
+
+	statuses := make([]Status, 0, len(keys))
+	for _, k := range keys {
+		r.mu.Lock()
+		b := r.breakers[k]
+		r.mu.Unlock()
+		statuses = append(statuses, Status{Key: k, State: b.State(), Counts: b.Counts()})
+	}
+	return statuses
+}
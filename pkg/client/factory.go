@@ -2,10 +2,12 @@ package client
 
 import (
 	"context"
-	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/clouway/go-epay/pkg/client/breaker"
+	"github.com/clouway/go-epay/pkg/client/retry"
 	"github.com/clouway/go-epay/pkg/client/telcong"
 	"github.com/clouway/go-epay/pkg/client/ucrm"
 	"github.com/clouway/go-epay/pkg/epay"
@@ -14,47 +16,114 @@ import (
 	"cloud.google.com/go/datastore"
 )
 
+// ucrmTaxesTTL is the time for which the rates of the UCRM taxes are cached.
+const ucrmTaxesTTL = time.Hour
+
+// Backends of the billing.
+const (
+	BackendTelcoNG = "telcong"
+	BackendUCRM    = "ucrm"
+)
+
 // NewClientFactory creates a new Factory for Client creation.
 func NewClientFactory(dClient *datastore.Client) epay.ClientFactory {
-	return &clientFactory{dClient}
+	return &clientFactory{dClient: dClient, ucrmClients: ucrm.NewClientCache(), ucrmTaxes: ucrm.NewTaxCache()}
 }
 
-type clientFactory struct {
-	dClient *datastore.Client
+// NewClientFactoryWithBreakers creates a new Factory for Client creation where each client
+// is called through the circuit breaker of it's environment and backend. The environment
+// is identified by the name which is returned by the provided tenant func.
+func NewClientFactoryWithBreakers(dClient *datastore.Client, breakers *breaker.Registry, tenant func(context.Context) string) epay.ClientFactory {
+	return &clientFactory{dClient: dClient, breakers: breakers, tenant: tenant, ucrmClients: ucrm.NewClientCache(), ucrmTaxes: ucrm.NewTaxCache()}
 }
 
-func (c *clientFactory) Create(ctx context.Context, env epay.Environment, idn string) epay.Client {
-	billingURL, _ := url.Parse(env.BillingURL)
+type clientFactory struct {
+	dClient  *datastore.Client
+	breakers *breaker.Registry
+	tenant   func(context.Context) string
+
+	// ucrmClients is the cache of the UCRM clients of the subscribers, which is shared
+	// by the clients of all environments.
+	ucrmClients *ucrm.ClientCache
+
+	// ucrmTaxes is the cache of the rates of the taxes of the UCRM instances.
+	ucrmTaxes *ucrm.TaxCache
+}
 
+func (c *clientFactory) Create(ctx context.Context, env epay.Environment, idn string) (epay.Client, error) {
 	if isTelcoNGContractCode(idn) && env.BillingJWTKey != "" && env.BillingURL != "" {
-		conf, _ := google.JWTConfigFromJSON([]byte(env.BillingJWTKey))
-		oauth2client := conf.Client(ctx)
-		return telcong.NewClient(oauth2client, billingURL)
+		return c.telcongClient(ctx, env)
 	}
 
-	if billingURL, ok := env.Metadata["billingUrl"]; ok {
-		billingURL, _ := url.Parse(billingURL)
-		apiKey := env.Metadata["apiKey"]
-		methodID := env.Metadata["methodId"]
-		providerName := env.Metadata["providerName"]
-		providerPaymentID := env.Metadata["providerPaymentId"]
-		providerPaymentTime := env.Metadata["providerPaymentTime"]
-		organizationID := env.Metadata["organizationId"]
-
-		return ucrm.NewClient(billingURL, apiKey, c.dClient, ucrm.PaymentProvider{
-			MethodID:       methodID,
-			Name:           providerName,
-			PaymentID:      providerPaymentID,
-			PaymentTime:    providerPaymentTime,
-			OrganizationID: organizationID,
-		})
-
+	conf, err := env.UCRMConfig()
+	if err != nil {
+		return nil, err
+	}
+	if conf != nil {
+		// the payment orders of UCRM are kept in Datastore
+		if c.dClient == nil {
+			return nil, &epay.ConfigError{Field: "metadata", Reason: "UCRM billing requires Datastore, which is not configured"}
+		}
+		provider := ucrm.PaymentProvider{
+			MethodID:       conf.MethodID,
+			Name:           conf.ProviderName,
+			PaymentID:      conf.ProviderPaymentID,
+			PaymentTime:    conf.ProviderPaymentTime,
+			OrganizationID: conf.OrganizationID,
+			Allocation:     conf.PaymentAllocation,
+			RequoteOrders:  conf.RequoteOrders,
+			OrderExpiry:    conf.OrderExpiry,
+			ExpiredOrders:  conf.ExpiredOrders,
+		}
+		invoices := ucrm.InvoiceSelection{
+			Statuses:      conf.InvoiceStatuses,
+			Types:         conf.InvoiceTypes,
+			PageSize:      conf.InvoicePageSize,
+			Concurrency:   conf.InvoiceConcurrency,
+			CreditNetting: conf.CreditNetting,
+		}
+		lookup := ucrm.SubscriberLookup{
+			Strategies: conf.SubscriberLookup,
+			Ambiguous:  conf.AmbiguousSubscribers,
+			Cache:      c.ucrmClients,
+			CacheTTL:   conf.SubscriberCacheTTL,
+		}
+		opts := ucrm.Options{Invoices: invoices, Lookup: lookup, Retry: retry.DefaultOptions, Taxes: c.ucrmTaxes, TaxesTTL: ucrmTaxesTTL}
+		client := ucrm.NewClientWithOptions(conf.BillingURL, conf.APIKey, c.dClient, provider, opts)
+		return c.withBreaker(ctx, env, BackendUCRM, client), nil
 	}
 
 	// Default to telcong client
-	conf, _ := google.JWTConfigFromJSON([]byte(env.BillingJWTKey))
-	oauth2client := conf.Client(ctx)
-	return telcong.NewClient(oauth2client, billingURL)
+	return c.telcongClient(ctx, env)
+}
+
+// withBreaker wraps the client of the backend with it's circuit breaker.
+func (c *clientFactory) withBreaker(ctx context.Context, env epay.Environment, backend string, client epay.Client) epay.Client {
+	if c.breakers == nil || (env.CircuitBreaker != nil && env.CircuitBreaker.Disabled) {
+		return client
+	}
+
+	settings := breaker.DefaultSettings
+	if cb := env.CircuitBreaker; cb != nil {
+		settings = breaker.Settings{
+			FailureThreshold: cb.FailureThreshold,
+			OpenTimeout:      time.Duration(cb.OpenSeconds) * time.Second,
+			HalfOpenRequests: cb.HalfOpenRequests,
+		}
+	}
+	return breaker.NewClient(client, c.breakers.Get(c.tenant(ctx)+"/"+backend, settings))
+}
+
+func (c *clientFactory) telcongClient(ctx context.Context, env epay.Environment) (epay.Client, error) {
+	conf, err := env.TelcoNGConfig()
+	if err != nil {
+		return nil, err
+	}
+	jwtConf, err := google.JWTConfigFromJSON(conf.JWTKey)
+	if err != nil {
+		return nil, &epay.ConfigError{Field: "billingKey", Reason: err.Error()}
+	}
+	return c.withBreaker(ctx, env, BackendTelcoNG, telcong.NewClient(jwtConf.Client(ctx), conf.BillingURL)), nil
 }
 
 // isTelcoNGContractCode validates the provided code using the checksum algorithm
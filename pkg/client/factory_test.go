@@ -1,7 +1,11 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"testing"
+
+	"github.com/clouway/go-epay/pkg/epay"
 )
 
 func TestValidate(t *testing.T) {
@@ -55,3 +59,14 @@ func TestValidate(t *testing.T) {
 		}
 	})
 }
+
+func TestUCRMClientRequiresDatastore(t *testing.T) {
+	env := epay.Environment{Metadata: map[string]string{"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1"}}
+
+	_, err := NewClientFactory(nil).Create(context.Background(), env, "1234")
+
+	var configErr *epay.ConfigError
+	if !errors.As(err, &configErr) {
+		t.Errorf("expected: config error, got: %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+// Package retry provides an HTTP layer for the billing clients which is applying per-attempt
+// timeouts and retries with exponential backoff. Requests are retried only when it's safe,
+// i.e the request method is safe, the request carries an idempotency key or the backend
+// confirms that the previous attempt was not applied.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// IdempotencyKeyHeader is the header which carries the idempotency key of the request.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ErrApplied is returned when request was not retried as it's previous attempt was applied
+// by the backend.
+var ErrApplied = errors.New("request was already applied by the backend")
+
+// Options are the options of the retries.
+type Options struct {
+	// Timeout is the timeout of each attempt including the reading of the response body.
+	Timeout time.Duration
+
+	// MaxAttempts is the maximum number of attempts of a single request.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry which is doubled on each next retry.
+	BaseDelay time.Duration
+
+	// MaxDelay is the maximum delay between two attempts.
+	MaxDelay time.Duration
+}
+
+// DefaultOptions are the options used by the billing clients when no options are provided.
+var DefaultOptions = Options{
+	Timeout:     10 * time.Second,
+	MaxAttempts: 3,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// Verifier checks whether the previous attempt of a request which is not idempotent was applied
+// by the backend, so the request should not be retried.
+type Verifier func(ctx context.Context) (applied bool, err error)
+
+// Client is an HTTP client which is retrying the failed requests.
+type Client struct {
+	httpClient *http.Client
+	opts       Options
+}
+
+// NewClient creates a new client which sends the requests with the provided HTTP client.
+func NewClient(httpClient *http.Client, opts Options) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	return &Client{httpClient: httpClient, opts: opts}
+}
+
+// Do sends the provided request. Requests with safe methods or carrying an idempotency key
+// are retried on network errors and on responses indicating temporary unavailability of
+// the backend, while all other requests are sent once.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if !idempotent(req) {
+		return c.do(req, 1, nil)
+	}
+	return c.do(req, c.opts.MaxAttempts, nil)
+}
+
+// DoVerified sends the provided request which is not idempotent. It's retried only when it
+// was not sent or the backend responded with temporary unavailability, as a request which
+// timed out or failed while it was sent could still be applied after the verification.
+// Before each retry the verifier is called and ErrApplied is returned when the previous
+// attempt was applied.
+func (c *Client) DoVerified(req *http.Request, verify Verifier) (*http.Response, error) {
+	return c.do(req, c.opts.MaxAttempts, verify)
+}
+
+func (c *Client) do(req *http.Request, attempts int, verify Verifier) (*http.Response, error) {
+	ctx := req.Context()
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, c.delay(attempt)); err != nil {
+				return nil, lastErr
+			}
+			if verify != nil {
+				applied, err := verify(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("could not verify previous attempt due: %v (attempt failed due: %v)", err, lastErr)
+				}
+				if applied {
+					return nil, ErrApplied
+				}
+			}
+		}
+
+		resp, sent, err := c.attempt(req)
+		if err == nil && !retryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if err != nil {
+			// the request was cancelled by the caller, so it's not retried
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			// the request which is not idempotent could be still processed by the backend
+			if verify != nil && sent {
+				return nil, err
+			}
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("got status %s", resp.Status)
+			if attempt == attempts-1 {
+				return resp, nil
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+	return nil, lastErr
+}
+
+// attempt sends a single attempt of the request using the timeout of the attempt. The
+// returned bool reports whether the request was written to the connection.
+func (c *Client) attempt(req *http.Request) (*http.Response, bool, error) {
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if c.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+	}
+
+	var sent int32
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteHeaders: func() { atomic.StoreInt32(&sent, 1) },
+	})
+
+	r := req.WithContext(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, false, err
+		}
+		r.Body = body
+	}
+
+	resp, err := c.httpClient.Do(r)
+	if err != nil {
+		cancel()
+		return nil, atomic.LoadInt32(&sent) == 1, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, true, nil
+}
+
+// delay gets the delay before the provided attempt using exponential backoff with full jitter.
+func (c *Client) delay(attempt int) time.Duration {
+	d := c.opts.BaseDelay << uint(attempt-1)
+	if d <= 0 || (c.opts.MaxDelay > 0 && d > c.opts.MaxDelay) {
+		d = c.opts.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// cancelOnClose cancels the context of the attempt when the response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
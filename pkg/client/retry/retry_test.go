@@ -0,0 +1,190 @@
+package retry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testOptions = Options{Timeout: time.Second, MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestRetries(t *testing.T) {
+	cases := []struct {
+		name         string
+		method       string
+		key          string
+		wantAttempts int32
+		wantStatus   int
+	}{
+		{"safe method is retried", "GET", "", 3, http.StatusOK},
+		{"post is not retried", "POST", "", 1, http.StatusServiceUnavailable},
+		{"post with idempotency key is retried", "POST", "key", 3, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var attempts int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if r.Method == "POST" && string(body) != "body" {
+					t.Errorf("expected body to be sent on each attempt, but got: %s", body)
+				}
+				if atomic.AddInt32(&attempts, 1) < 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			req, _ := http.NewRequest(c.method, ts.URL, strings.NewReader("body"))
+			if c.key != "" {
+				req.Header.Set(IdempotencyKeyHeader, c.key)
+			}
+
+			resp, err := NewClient(nil, testOptions).Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != c.wantStatus {
+				t.Errorf("expected: %d, got: %d", c.wantStatus, resp.StatusCode)
+			}
+			if got := atomic.LoadInt32(&attempts); got != c.wantAttempts {
+				t.Errorf("expected: %d attempts, got: %d", c.wantAttempts, got)
+			}
+		})
+	}
+}
+
+func TestDoVerified(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	c := NewClient(nil, testOptions)
+
+	req, _ := http.NewRequest("POST", ts.URL, strings.NewReader("body"))
+	_, err := c.DoVerified(req, func(ctx context.Context) (bool, error) { return true, nil })
+	if err != ErrApplied {
+		t.Errorf("expected: %v, got: %v", ErrApplied, err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("expected applied request to not be retried, but got %d attempts", got)
+	}
+
+	atomic.StoreInt32(&attempts, 0)
+	req, _ = http.NewRequest("POST", ts.URL, strings.NewReader("body"))
+	resp, err := c.DoVerified(req, func(ctx context.Context) (bool, error) { return false, nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("expected not applied request to be retried, but got %d attempts", got)
+	}
+}
+
+func TestVerifiedRequestIsNotRetriedAfterTimeout(t *testing.T) {
+	var attempts, verifications int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the body is read, so the closing of the connection is noticed
+		ioutil.ReadAll(r.Body)
+		atomic.AddInt32(&attempts, 1)
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	opts := testOptions
+	opts.Timeout = 50 * time.Millisecond
+
+	req, _ := http.NewRequest("POST", ts.URL, strings.NewReader("body"))
+	_, err := NewClient(nil, opts).DoVerified(req, func(ctx context.Context) (bool, error) {
+		atomic.AddInt32(&verifications, 1)
+		return false, nil
+	})
+	if err == nil {
+		t.Fatalf("expected timed out request to fail")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("expected timed out request to not be retried, but got %d attempts", got)
+	}
+	if got := atomic.LoadInt32(&verifications); got != 0 {
+		t.Errorf("expected no verifications, got: %d", got)
+	}
+}
+
+func TestVerifiedRequestIsRetriedWhenNotSent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	addr := ts.URL
+	ts.Close()
+
+	var verifications int32
+	req, _ := http.NewRequest("POST", addr, strings.NewReader("body"))
+	_, err := NewClient(nil, testOptions).DoVerified(req, func(ctx context.Context) (bool, error) {
+		atomic.AddInt32(&verifications, 1)
+		return false, nil
+	})
+	if err == nil {
+		t.Fatalf("expected request to closed server to fail")
+	}
+	if got := atomic.LoadInt32(&verifications); got != 2 {
+		t.Errorf("expected request which was not sent to be retried, but got %d verifications", got)
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	opts := testOptions
+	opts.Timeout = 50 * time.Millisecond
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := NewClient(nil, opts).Do(req)
+	if err != nil {
+		t.Fatalf("expected timed out attempt to be retried, but got: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "ok" {
+		t.Errorf("expected: ok, got: %s", body)
+	}
+}
+
+func TestCancelledRequestIsNotRetried(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	if _, err := NewClient(nil, testOptions).Do(req.WithContext(ctx)); err == nil {
+		t.Fatalf("expected cancelled request to fail")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("expected cancelled request to not be retried, but got %d attempts", got)
+	}
+}
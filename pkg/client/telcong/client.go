@@ -4,16 +4,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/client/retry"
 	"github.com/clouway/go-epay/pkg/epay"
 )
 
 const (
 	userAgent = "telcong-golang-epay/20180125"
+
+	// backend is the name of the backend which is reported in the errors
+	backend = "telcong"
 )
 
 // client is an HTTP client which uses API endpoints provided by the platform
@@ -21,17 +30,18 @@ const (
 type client struct {
 	BaseURL *url.URL
 
-	httpClient *http.Client
+	httpClient *retry.Client
 }
 
 // NewClient creates a new HTTP client by using the provided baseURL.
 func NewClient(httpClient *http.Client, baseURL *url.URL) epay.Client {
-	if httpClient == nil {
-		httpClient = http.DefaultClient
-	}
+	return NewClientWithOptions(httpClient, baseURL, retry.DefaultOptions)
+}
 
-	c := &client{httpClient: httpClient, BaseURL: baseURL}
-	return c
+// NewClientWithOptions creates a new HTTP client by using the provided baseURL which
+// is using the provided timeouts and retries.
+func NewClientWithOptions(httpClient *http.Client, baseURL *url.URL, opts retry.Options) epay.Client {
+	return &client{httpClient: retry.NewClient(httpClient, opts), BaseURL: baseURL}
 }
 
 // GetSubscriberDuties gets current subscriber duties.
@@ -43,16 +53,16 @@ func (c *client) GetSubscriberDuties(ctx context.Context, subscriberID string) (
 	var duties epay.SubscriberDuties
 	resp, err := c.do(req, &duties)
 	if err != nil {
-		return nil, fmt.Errorf("could not process get subscriber duties request due: %v", err)
+		return nil, billingError("GetSubscriberDuties", nil, err)
 	}
 	if resp.StatusCode == http.StatusOK {
 		return &duties, nil
 	}
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, epay.ErrSubscriberNotFound
+		return nil, billingError("GetSubscriberDuties", resp, epay.ErrSubscriberNotFound)
 	}
 
-	return nil, fmt.Errorf("unknown repospone during retrieving of subscriber duties: %v", err)
+	return nil, billingError("GetSubscriberDuties", resp, nil)
 }
 
 // CreatePaymentOrder creates a new PaymentOrder in the target system using the provided request.
@@ -62,22 +72,40 @@ func (c *client) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePa
 		return nil, fmt.Errorf("could not create request due: %v", err)
 	}
 
+	// The payment order is registered with the id of the transaction, so the request
+	// is retried only when the order was not registered by the previous attempt.
+	var created *epay.PaymentOrder
+	verify := func(ctx context.Context) (bool, error) {
+		po, err := c.GetPaymentOrder(ctx, createReq.TransactionID)
+		if errors.Is(err, epay.ErrPaymentOrderNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		created = po
+		return true, nil
+	}
+
 	var paymentOrder epay.PaymentOrder
-	resp, err := c.do(req, &paymentOrder)
+	resp, err := c.doVerified(req, verify, &paymentOrder)
+	if errors.Is(err, retry.ErrApplied) {
+		return created, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("could not process create order request due: %v", err)
+		return nil, billingError("CreatePaymentOrder", nil, err)
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, epay.ErrSubscriberNotFound
+		return nil, billingError("CreatePaymentOrder", resp, epay.ErrSubscriberNotFound)
 	}
 
 	if resp.StatusCode == http.StatusBadRequest {
-		return nil, epay.ErrPaymentOrderAlreadyExists
+		return nil, billingError("CreatePaymentOrder", resp, epay.ErrPaymentOrderAlreadyExists)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, epay.ErrUnknown
+		return nil, billingError("CreatePaymentOrder", resp, nil)
 	}
 
 	return &paymentOrder, nil
@@ -85,18 +113,36 @@ func (c *client) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePa
 
 // GetPaymentOrder gets the PaymentOrder which is associated with the provided orderKey
 func (c *client) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.PaymentOrder, error) {
+	po, err := c.getPaymentOrder(ctx, orderKey)
+	if err != nil {
+		return nil, err
+	}
+	return &po.PaymentOrder, nil
+}
+
+// paidOrder is the payment order together with the time when it was paid.
+type paidOrder struct {
+	epay.PaymentOrder
+	PaidOn time.Time `json:"paidOn"`
+}
+
+func (c *client) getPaymentOrder(ctx context.Context, orderKey string) (*paidOrder, error) {
 	req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/v1/paymentorders/%s", orderKey), nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not create request due: %v", err)
 	}
-	var paymentOrder epay.PaymentOrder
+	var paymentOrder paidOrder
 	resp, err := c.do(req, &paymentOrder)
 	if err != nil {
-		return nil, fmt.Errorf("cannot retrieve payment order due: %v", err)
+		return nil, billingError("GetPaymentOrder", nil, err)
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, epay.ErrPaymentOrderNotFound
+		return nil, billingError("GetPaymentOrder", resp, epay.ErrPaymentOrderNotFound)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, billingError("GetPaymentOrder", resp, nil)
 	}
 
 	return &paymentOrder, nil
@@ -105,15 +151,41 @@ func (c *client) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.Pa
 // PayPaymentOrder performs payment of the the order associated with the providing
 // the ID of the order or the transactionID associated with it.
 func (c *client) PayPaymentOrder(ctx context.Context, orderID string) (*epay.PayPaymentOrderResponse, error) {
-	req, err := c.newRequest(context.Background(), "POST", fmt.Sprintf("/v1/paymentorders/%s/pay", orderID), nil)
+	req, err := c.newRequest(ctx, "POST", fmt.Sprintf("/v1/paymentorders/%s/pay", orderID), nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not create request due: %v", err)
 	}
 
+	// The payment is not idempotent, so it's retried only when the order was not
+	// paid by the previous attempt, in which case the payment is successful.
+	var paid *paidOrder
+	verify := func(ctx context.Context) (bool, error) {
+		po, err := c.getPaymentOrder(ctx, orderID)
+		if err != nil {
+			return false, err
+		}
+		if po.PaidOn.IsZero() {
+			return false, nil
+		}
+		paid = po
+		return true, nil
+	}
+
 	var paymentResponse epay.PayPaymentOrderResponse
-	resp, err := c.do(req, &paymentResponse)
+	resp, err := c.doVerified(req, verify, &paymentResponse)
+	if errors.Is(err, retry.ErrApplied) {
+		return &epay.PayPaymentOrderResponse{
+			ID:            paid.ID,
+			CustomerName:  paid.CustomerName,
+			TransactionID: paid.TransactionID,
+			Amount:        paid.Amount,
+			Created:       paid.Created,
+			PaidOn:        paid.PaidOn,
+			Items:         paid.Items,
+		}, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to process payment request due: %v", err)
+		return nil, billingError("PayPaymentOrder", nil, err)
 	}
 
 	if resp.StatusCode == http.StatusOK {
@@ -121,17 +193,20 @@ func (c *client) PayPaymentOrder(ctx context.Context, orderID string) (*epay.Pay
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, epay.ErrPaymentOrderNotFound
+		return nil, billingError("PayPaymentOrder", resp, epay.ErrPaymentOrderNotFound)
 	}
 
-	if resp.StatusCode == http.StatusBadRequest {
-		er, derr := decodeResponse(resp.Body)
-		if derr == nil && er.Message == "Payment order is already paid." {
-			return nil, epay.ErrPaymentOrderAlreadyPaid
-		}
+	be := billingError("PayPaymentOrder", resp, nil)
+	if resp.StatusCode == http.StatusConflict {
+		be.Err = epay.ErrPaymentOrderAlreadyPaid
+		return nil, be
 	}
-
-	return nil, epay.ErrUnknown
+	// older TelcoNG versions are reporting paid orders only with the message of the error
+	if resp.StatusCode == http.StatusBadRequest && strings.Contains(strings.ToLower(be.Excerpt), "already paid") {
+		log.WithContext(ctx).Warnf("payment order '%s' is reported as paid only by the message: %s", orderID, be.Excerpt)
+		be.Err = epay.ErrPaymentOrderAlreadyPaid
+	}
+	return nil, be
 }
 
 func (c *client) newRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
@@ -161,6 +236,15 @@ func (c *client) newRequest(ctx context.Context, method, path string, body inter
 
 func (c *client) do(req *http.Request, v interface{}) (*http.Response, error) {
 	resp, err := c.httpClient.Do(req)
+	return decode(resp, err, v)
+}
+
+func (c *client) doVerified(req *http.Request, verify retry.Verifier, v interface{}) (*http.Response, error) {
+	resp, err := c.httpClient.DoVerified(req, verify)
+	return decode(resp, err, v)
+}
+
+func decode(resp *http.Response, err error, v interface{}) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
@@ -172,13 +256,11 @@ func (c *client) do(req *http.Request, v interface{}) (*http.Response, error) {
 	return resp, err
 }
 
-func decodeResponse(r io.ReadCloser) (*errorResponse, error) {
-	defer r.Close()
-	resp := errorResponse{}
-	err := json.NewDecoder(r).Decode(&resp)
-	return &resp, err
-}
-
-type errorResponse struct {
-	Message string `json:"message"`
+// billingError creates a new BillingError of the operation and closes the body of the response.
+func billingError(op string, resp *http.Response, err error) *epay.BillingError {
+	be := epay.NewBillingError(backend, op, resp, err)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return be
 }
@@ -3,12 +3,15 @@ package telcong
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/clouway/go-epay/pkg/client/retry"
 	"github.com/clouway/go-epay/pkg/epay"
 )
 
@@ -43,7 +46,7 @@ func TestGetDutiesOfUnknownSubscriber(t *testing.T) {
 
 	client := NewClient(nil, baseURL)
 	_, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
-	if err != epay.ErrSubscriberNotFound {
+	if !errors.Is(err, epay.ErrSubscriberNotFound) {
 		t.Fatalf("not existing subscriber response was returned as: %v", err)
 	}
 }
@@ -109,7 +112,7 @@ func TestTryToCreateOrderForNotExistingSubscriber(t *testing.T) {
 
 	_, err := client.CreatePaymentOrder(context.Background(), epay.CreatePaymentOrderRequest{SubscriberID: "::unknown::", TransactionID: "TID2"})
 
-	if err != epay.ErrSubscriberNotFound {
+	if !errors.Is(err, epay.ErrSubscriberNotFound) {
 		t.Errorf("	expected: %v", epay.ErrSubscriberNotFound)
 		t.Errorf("	     got: %v", err)
 	}
@@ -126,7 +129,7 @@ func TestPaymentOrderAlreadyExists(t *testing.T) {
 
 	_, err := client.CreatePaymentOrder(context.Background(), epay.CreatePaymentOrderRequest{SubscriberID: "::unknown::", TransactionID: "TID2"})
 
-	if err != epay.ErrPaymentOrderAlreadyExists {
+	if !errors.Is(err, epay.ErrPaymentOrderAlreadyExists) {
 		t.Errorf("	expected: %v", epay.ErrPaymentOrderAlreadyExists)
 		t.Errorf("	     got: %v", err)
 	}
@@ -165,7 +168,7 @@ func TestPayUnknownPaymentOrder(t *testing.T) {
 
 	_, err := client.PayPaymentOrder(context.Background(), "::unknown order id::")
 
-	if err != epay.ErrPaymentOrderNotFound {
+	if !errors.Is(err, epay.ErrPaymentOrderNotFound) {
 		t.Errorf("	expected: %v", epay.ErrPaymentOrderNotFound)
 		t.Errorf("	     got: %v", err)
 	}
@@ -175,7 +178,7 @@ func TestPayUnknownPaymentOrder(t *testing.T) {
 func TestPayAlreadyPaidPaymentOrder(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
-		jsonReply(w, &errorResponse{Message: "Payment order is already paid."})
+		jsonReply(w, map[string]string{"message": "Payment order is already paid."})
 	}))
 	defer ts.Close()
 	baseURL, _ := url.Parse(ts.URL)
@@ -184,7 +187,7 @@ func TestPayAlreadyPaidPaymentOrder(t *testing.T) {
 
 	_, err := client.PayPaymentOrder(context.Background(), "::paid order id::")
 
-	if err != epay.ErrPaymentOrderAlreadyPaid {
+	if !errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) {
 		t.Errorf("	expected: %v", epay.ErrPaymentOrderAlreadyPaid)
 		t.Errorf("	     got: %v", err)
 	}
@@ -202,11 +205,36 @@ func TestPaymentFailsWithServerError(t *testing.T) {
 
 	_, err := client.PayPaymentOrder(context.Background(), "::order id::")
 
-	if err != epay.ErrUnknown {
+	if !errors.Is(err, epay.ErrUnknown) {
 		t.Errorf("	expected: %v", epay.ErrUnknown)
 		t.Errorf("	     got: %v", err)
 	}
 
+	var be *epay.BillingError
+	if !errors.As(err, &be) {
+		t.Fatalf("expected billing error, got: %v", err)
+	}
+	if be.StatusCode != http.StatusInternalServerError || !be.Retryable || be.Excerpt != "internal" {
+		t.Errorf("expected: retryable error with status 500 and excerpt, got: %+v", be)
+	}
+
+}
+
+func TestPayPaymentOrderWithConflict(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	client := NewClient(nil, baseURL)
+
+	_, err := client.PayPaymentOrder(context.Background(), "::paid order id::")
+
+	if !errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) {
+		t.Errorf("	expected: %v", epay.ErrPaymentOrderAlreadyPaid)
+		t.Errorf("	     got: %v", err)
+	}
 }
 
 func TestGetPaymentOrder(t *testing.T) {
@@ -240,7 +268,7 @@ func TestGetUnknownPaymentOrder(t *testing.T) {
 	client := NewClient(nil, baseURL)
 
 	_, err := client.GetPaymentOrder(context.Background(), "1")
-	if err != epay.ErrPaymentOrderNotFound {
+	if !errors.Is(err, epay.ErrPaymentOrderNotFound) {
 		t.Errorf("	expected: %v", epay.ErrPaymentOrderNotFound)
 		t.Errorf("	     got: %v", err)
 	}
@@ -264,3 +292,64 @@ func jsonReply(w http.ResponseWriter, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonVal)
 }
+
+func TestCreatePaymentOrderIsNotRetriedWhenRegistered(t *testing.T) {
+	order := &epay.PaymentOrder{ID: "1", TransactionID: "::tid::"}
+	posts := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/paymentorders", func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/v1/paymentorders/::tid::", func(w http.ResponseWriter, r *http.Request) {
+		jsonReply(w, order)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	client := NewClientWithOptions(nil, baseURL, retry.Options{MaxAttempts: 3})
+	got, err := client.CreatePaymentOrder(context.Background(), epay.CreatePaymentOrderRequest{SubscriberID: "::subscriber id::", TransactionID: "::tid::"})
+	if err != nil {
+		t.Fatalf("unable to create payment order due: %v", err)
+	}
+	if !reflect.DeepEqual(got, order) {
+		t.Errorf("expected: %v, got: %v", order, got)
+	}
+	if posts != 1 {
+		t.Errorf("expected registered order to not be created again, but got %d requests", posts)
+	}
+}
+
+func TestPayPaymentOrderIsNotRetriedWhenPaid(t *testing.T) {
+	paidOn := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	posts := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/paymentorders/::order id::/pay", func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		if r.Header.Get(retry.IdempotencyKeyHeader) != "" {
+			t.Errorf("expected payment to be sent without idempotency key")
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/v1/paymentorders/::order id::", func(w http.ResponseWriter, r *http.Request) {
+		jsonReply(w, map[string]interface{}{"id": "::order id::", "transactionId": "::tid::", "paidOn": paidOn})
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	client := NewClientWithOptions(nil, baseURL, retry.Options{MaxAttempts: 3})
+	got, err := client.PayPaymentOrder(context.Background(), "::order id::")
+	if err != nil {
+		t.Fatalf("expected paid order to be successful, but got: %v", err)
+	}
+	if got.TransactionID != "::tid::" || !got.PaidOn.Equal(paidOn) {
+		t.Errorf("expected: paid order of ::tid::, got: %+v", got)
+	}
+	if posts != 1 {
+		t.Errorf("expected paid order to not be paid again, but got %d requests", posts)
+	}
+}
@@ -4,34 +4,114 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/clouway/go-epay/pkg/client/retry"
 	"github.com/clouway/go-epay/pkg/epay"
 
 	"cloud.google.com/go/datastore"
 )
 
-const poKind = "PaymentOrder"
+const (
+	poKind = "PaymentOrder"
+
+	// backend is the name of the backend which is reported in the errors
+	backend = "ucrm"
+
+	// paymentsPageSize is the number of payments which are fetched with a single request
+	paymentsPageSize = 100
+
+	// paymentTimeSkew is the allowed difference between the clocks of ePay, UCRM and goepay
+	paymentTimeSkew = time.Hour
+)
 
 // PaymentProvider is keeping the configured payment provider in UCRM.
 type PaymentProvider struct {
-	MethodID       string
-	Name           string
-	PaymentID      string
-	PaymentTime    string
+	MethodID string
+
+	// Name is the name of the provider, which is the source of the payments in UCRM.
+	Name string
+
+	// PaymentID is the template of the provider payment ID, in which {tid} and {idn} are
+	// replaced with the transaction and the subscriber of the order. The transaction is
+	// used as payment ID when it's empty.
+	PaymentID string
+
+	// PaymentTime is the time zone in which the provider payment time is posted. UTC is
+	// used when it's empty.
+	PaymentTime string
+
 	OrganizationID string
+
+	// Allocation is the allocation of the payments to the invoices, which is one of
+	// epay.UCRMAllocationInvoices or epay.UCRMAllocationAuto.
+	Allocation string
+
+	// RequoteOrders indicates whether the unpaid orders are quoted again with the current
+	// duties of the subscriber when the order of the same transaction is created again.
+	RequoteOrders bool
+
+	// OrderExpiry is the time after which the unpaid orders are expired. Orders are not
+	// expiring when it's zero.
+	OrderExpiry time.Duration
+
+	// ExpiredOrders is the handling of the expired orders during the confirmation, which
+	// is one of epay.UCRMExpiredOrdersReject or epay.UCRMExpiredOrdersRevalidate.
+	ExpiredOrders string
+}
+
+// Options are the options of the client.
+type Options struct {
+	// Invoices is the selection of the invoices which are included in the duties.
+	Invoices InvoiceSelection
+
+	// Lookup is the lookup of the clients of the subscribers.
+	Lookup SubscriberLookup
+
+	// Retry are the timeouts and retries of the requests.
+	Retry retry.Options
+
+	// Taxes is the cache of the rates of the taxes, which are kept for TaxesTTL. The rates
+	// are fetched for each request when it's nil.
+	Taxes    *TaxCache
+	TaxesTTL time.Duration
+}
+
+// DefaultOptions are the options which are used by NewClient.
+var DefaultOptions = Options{
+	Invoices: DefaultInvoiceSelection,
+	Lookup:   DefaultSubscriberLookup,
+	Retry:    retry.DefaultOptions,
 }
 
 // NewClient creates a new client that uses the provided app key and baseURL.
 func NewClient(baseURL *url.URL, appKey string, dClient *datastore.Client, paymentProvider PaymentProvider) epay.Client {
-	return &client{BaseURL: baseURL, AppKey: appKey, dClient: dClient, paymentProvider: paymentProvider}
+	return NewClientWithOptions(baseURL, appKey, dClient, paymentProvider, DefaultOptions)
+}
+
+// NewClientWithOptions creates a new client that uses the provided app key and baseURL
+// and is using the provided options.
+func NewClientWithOptions(baseURL *url.URL, appKey string, dClient *datastore.Client, paymentProvider PaymentProvider, opts Options) epay.Client {
+	return &client{
+		BaseURL:         baseURL,
+		AppKey:          appKey,
+		dClient:         dClient,
+		paymentProvider: paymentProvider,
+		invoices:        opts.Invoices,
+		lookup:          opts.Lookup,
+		taxes:           opts.Taxes,
+		taxesTTL:        opts.TaxesTTL,
+		httpClient:      retry.NewClient(&http.Client{}, opts.Retry),
+	}
 }
 
 type client struct {
@@ -39,13 +119,25 @@ type client struct {
 	AppKey          string
 	dClient         *datastore.Client
 	paymentProvider PaymentProvider
+	invoices        InvoiceSelection
+	lookup          SubscriberLookup
+	taxes           *TaxCache
+	taxesTTL        time.Duration
+	httpClient      *retry.Client
 }
 
 // GetSubscriberDuties gets current subscriber duties.
 func (c *client) GetSubscriberDuties(ctx context.Context, subscriberID string) (*epay.SubscriberDuties, error) {
+	duties, _, err := c.subscriberDuties(ctx, subscriberID)
+	return duties, err
+}
+
+// subscriberDuties gets current subscriber duties together with the amounts in coins which are
+// allocated to each of the invoices. The credit of the client is netted according to the policy.
+func (c *client) subscriberDuties(ctx context.Context, subscriberID string) (*epay.SubscriberDuties, []int, error) {
 	clientRef, err := c.findClientID(ctx, subscriberID)
 	if err != nil {
-		return nil, epay.ErrSubscriberNotFound
+		return nil, nil, err
 	}
 
 	clientID := strconv.Itoa(clientRef.ID)
@@ -54,75 +146,85 @@ func (c *client) GetSubscriberDuties(ctx context.Context, subscriberID string) (
 		customerName = clientRef.CompanyName
 	}
 
-	params := url.Values{}
-	params.Add("clientId", clientID)
-	params.Add("statuses[0]", "1")
-	params.Add("statuses[1]", "2")
-	params.Add("limit", "100")
-
-	req, err := c.newRequest(ctx, "GET", "/api/v1.0/invoices", params)
+	duties, err := c.listInvoices(ctx, clientID)
 	if err != nil {
-		return nil, fmt.Errorf("could not create request due: %v", err)
+		return nil, nil, err
 	}
-	var duties []invoice
-	resp, err := c.do(req, &duties)
+
+	rates, err := c.taxRates(ctx, duties)
 	if err != nil {
-		return nil, fmt.Errorf("could not process get subscriber duties request due: %v", err)
+		return nil, nil, err
 	}
 
-	if resp.StatusCode == http.StatusOK {
-		dutyAmount := 0.0
-		documentIDs := make([]string, 0)
-		items := make([]epay.Item, 0)
-		for _, duty := range duties {
-			dutyAmount += duty.Total - duty.AmountPaid
-			documentID := strconv.Itoa(duty.ID)
-			documentIDs = append(documentIDs, documentID)
+	remaining := make([]int, 0, len(duties))
+	documentIDs := make([]string, 0)
+	items := make([]epay.Item, 0)
+	for _, duty := range duties {
+		remaining = append(remaining, toCoins(duty.Total-duty.AmountPaid))
+		documentID := strconv.Itoa(duty.ID)
+		documentIDs = append(documentIDs, documentID)
 
-			for _, item := range duty.Items {
-				items = append(items, epay.Item{Name: item.Label})
-			}
-		}
+		items = append(items, toItems(duty, rates)...)
+	}
 
-		amount := fmt.Sprintf("%.2f", dutyAmount)
-		return &epay.SubscriberDuties{
-			CustomerName: customerName,
-			CustomerRef:  clientID,
-			DutyAmount:   epay.Amount{Value: amount},
-			DocumentIDs:  documentIDs,
-			Items:        items,
-		}, nil
+	allocated, credit := netDuties(remaining, clientRef, c.invoices.CreditNetting)
+	if credit > 0 {
+		items = append(items, creditItem(credit))
 	}
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, epay.ErrSubscriberNotFound
+
+	dutyAmount := 0
+	for _, a := range allocated {
+		dutyAmount += a
 	}
 
-	return nil, fmt.Errorf("unknown response during retrieving of subscriber duties: %v", err)
+	return &epay.SubscriberDuties{
+		CustomerName: customerName,
+		CustomerRef:  clientID,
+		DutyAmount:   epay.Amount{Value: formatCoins(dutyAmount)},
+		DocumentIDs:  documentIDs,
+		Items:        items,
+	}, allocated, nil
 }
 
+// CreatePaymentOrder creates the payment order of the transaction when it's not existing. An
+// existing unpaid order is returned as it is, unless re-quoting of orders is configured, while
+// epay.ErrPaymentOrderAlreadyExists is returned when it was paid or is of another subscriber.
 func (c *client) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePaymentOrderRequest) (*epay.PaymentOrder, error) {
 	contextLogger := log.WithContext(ctx)
 
-	duties, err := c.GetSubscriberDuties(ctx, createReq.SubscriberID)
+	quote, err := c.quote(ctx, createReq.SubscriberID, createReq.TransactionID)
 	if err != nil {
 		return nil, err
 	}
 
 	k := datastore.NameKey(poKind, createReq.TransactionID, nil)
+	expiry := c.paymentProvider.OrderExpiry
 
-	po := &paymentOrder{
-		CustomerName:  duties.CustomerName,
-		ClientID:      duties.CustomerRef,
-		TransactionID: createReq.TransactionID,
-		SubscriberID:  createReq.SubscriberID,
-		Amount:        duties.DutyAmount.Value,
-		CreatedAt:     time.Now(),
-		InvoiceIDs:    duties.DocumentIDs,
+	po := &paymentOrder{}
+	_, err = c.dClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		*po = paymentOrder{}
+		err := tx.Get(k, po)
+		if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+			return err
+		}
+		if err == nil {
+			// expired orders are quoted again with the current duties
+			requote := c.paymentProvider.RequoteOrders || po.expired(quote.CreatedAt, expiry)
+			reuse, err := po.reuse(createReq.SubscriberID, requote)
+			if err != nil || reuse {
+				return err
+			}
+		}
+		*po = *quote
+		_, err = tx.Put(k, po)
+		return err
+	})
+	if errors.Is(err, epay.ErrPaymentOrderAlreadyExists) {
+		return nil, err
 	}
-
-	if _, err := c.dClient.Put(ctx, k, po); err != nil {
+	if err != nil {
 		contextLogger.Printf("got error: %v", err)
-		return nil, epay.ErrUnknown
+		return nil, fmt.Errorf("could not store payment order due: %w", err)
 	}
 
 	return &epay.PaymentOrder{
@@ -131,16 +233,46 @@ func (c *client) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePa
 		TransactionID: po.TransactionID,
 		Amount:        epay.Amount{Value: po.Amount},
 		Created:       po.CreatedAt,
-		Items:         duties.Items,
+		Items:         po.items(),
 	}, nil
 }
 
+// quote creates a new payment order of the transaction with the current duties of the subscriber.
+func (c *client) quote(ctx context.Context, subscriberID, transactionID string) (*paymentOrder, error) {
+	duties, allocated, err := c.subscriberDuties(ctx, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the allocated amounts of the invoices are kept, so the payment is allocated
+	// to the invoices which were shown to the subscriber
+	invoiceAmounts := make([]string, 0, len(allocated))
+	for _, a := range allocated {
+		invoiceAmounts = append(invoiceAmounts, formatCoins(a))
+	}
+
+	po := &paymentOrder{
+		CustomerName:   duties.CustomerName,
+		ClientID:       duties.CustomerRef,
+		TransactionID:  transactionID,
+		SubscriberID:   subscriberID,
+		Amount:         duties.DutyAmount.Value,
+		CreatedAt:      time.Now(),
+		InvoiceIDs:     duties.DocumentIDs,
+		InvoiceAmounts: invoiceAmounts,
+	}
+	if err := po.setItems(duties.Items); err != nil {
+		return nil, err
+	}
+	return po, nil
+}
+
 func (c *client) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.PaymentOrder, error) {
 	k := datastore.NameKey(poKind, orderKey, nil)
 
 	po := &paymentOrder{}
 	if err := c.dClient.Get(ctx, k, po); err != nil {
-		return nil, epay.ErrPaymentOrderNotFound
+		return nil, orderError(err)
 	}
 
 	return &epay.PaymentOrder{
@@ -152,43 +284,69 @@ func (c *client) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.Pa
 	}, nil
 }
 
+// PayPaymentOrder pays the order with the provided id. The order is reserved for the payment
+// in a transaction, so concurrent or repeated confirmations are not paying it twice, and the
+// payment is posted only when it's not registered in UCRM by a previous confirmation.
 func (c *client) PayPaymentOrder(ctx context.Context, orderID string) (*epay.PayPaymentOrderResponse, error) {
 	k := datastore.NameKey(poKind, orderID, nil)
 
-	po := &paymentOrder{}
-	if err := c.dClient.Get(ctx, k, po); err != nil {
-		return nil, epay.ErrPaymentOrderNotFound
-	}
+	// datastore keeps the time in microseconds, so the lease is compared in the same precision
+	now := time.Now().Truncate(time.Microsecond)
+	expiry := c.paymentProvider.OrderExpiry
 
-	clientID, _ := strconv.Atoi(po.ClientID)
-	amount, _ := strconv.ParseFloat(po.Amount, 64)
-	paymentReq := &paymentRequest{
-		ClientID:          clientID,
-		MethodID:          c.paymentProvider.MethodID,
-		Amount:            amount,
-		ProviderName:      c.paymentProvider.Name,
-		ProviderPaymentID: po.TransactionID,
+	// the current duties of the subscriber are quoted for the revalidation of expired orders
+	var current *paymentOrder
+	if expiry > 0 && c.paymentProvider.ExpiredOrders == epay.UCRMExpiredOrdersRevalidate {
+		po := &paymentOrder{}
+		if err := c.dClient.Get(ctx, k, po); err != nil {
+			return nil, orderError(err)
+		}
+		if po.State == stateCreated && po.expired(now, expiry) {
+			q, err := c.quote(ctx, po.SubscriberID, po.TransactionID)
+			if err != nil {
+				return nil, err
+			}
+			current = q
+		}
 	}
 
-	req, err := c.newRequest(ctx, "POST", "/api/v1.0/payments", paymentReq)
+	po, err := c.updateOrder(ctx, k, func(po *paymentOrder) error {
+		if err := po.checkExpiry(now, expiry, current); err != nil {
+			return err
+		}
+		if err := po.startPayment(now); err != nil {
+			return err
+		}
+		if paidAt, ok := epay.PaymentTimeFromContext(ctx); ok {
+			po.PaidAt = paidAt
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not create request due: %v", err)
+		return nil, err
 	}
 
-	r := &paymentResponse{}
-	resp, err := c.do(req, &r)
+	payCtx, cancel := context.WithTimeout(ctx, payTimeout)
+	err = c.pay(payCtx, po)
+	cancel()
 	if err != nil {
-		return nil, fmt.Errorf("could not process payment request due: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusCreated {
-		return nil, epay.ErrUnknown
+		// the order is released, so the payment could be retried by the next confirmation
+		if _, aerr := c.updateOrder(ctx, k, func(po *paymentOrder) error {
+			po.abortPayment(now)
+			return nil
+		}); aerr != nil {
+			log.Printf("could not release payment order '%s' due: %v", orderID, aerr)
+		}
+		return nil, err
 	}
 
-	po.ProcessedOn = time.Now()
-	if _, err := c.dClient.Put(ctx, k, po); err != nil {
+	po, err = c.updateOrder(ctx, k, func(po *paymentOrder) error {
+		po.completePayment(time.Now())
+		return nil
+	})
+	if err != nil {
 		log.Printf("got error: %v", err)
-		return nil, epay.ErrUnknown
+		return nil, err
 	}
 
 	return &epay.PayPaymentOrderResponse{
@@ -196,34 +354,154 @@ func (c *client) PayPaymentOrder(ctx context.Context, orderID string) (*epay.Pay
 		TransactionID: po.TransactionID,
 		Amount:        epay.Amount{Value: po.Amount},
 		Created:       po.CreatedAt,
-		PaidOn:        po.ProcessedOn,
+		PaidOn:        po.PaidAt,
 	}, nil
 }
 
-func (c *client) findClientID(ctx context.Context, subscriberID string) (*clientRef, error) {
-	params := url.Values{}
-	params.Add("userIdent", subscriberID)
-	params.Add("organizationId", c.paymentProvider.OrganizationID)
+// pay posts the payment of the order to UCRM. The payment is not posted when it was
+// registered by a previous confirmation which crashed before the order was completed.
+func (c *client) pay(ctx context.Context, po *paymentOrder) error {
+	exists, err := c.paymentExists(ctx, po, c.paymentProvider.paymentID(po))
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
 
-	req, err := c.newRequest(ctx, "GET", "/api/v1.0/clients", params)
+	paymentReq := newPaymentRequest(po, c.paymentProvider)
+
+	req, err := c.newRequest(ctx, "POST", "/api/v1.0/payments", paymentReq)
 	if err != nil {
-		return nil, fmt.Errorf("could not create request due: %v", err)
+		return fmt.Errorf("could not create request due: %v", err)
+	}
+
+	// UCRM is not supporting idempotency keys, so the payment is retried only
+	// when it was not registered by the previous attempt.
+	verify := func(ctx context.Context) (bool, error) {
+		return c.paymentExists(ctx, po, paymentReq.ProviderPaymentID)
+	}
+
+	r := &paymentResponse{}
+	resp, err := c.doVerified(req, verify, &r)
+	if err != nil && !errors.Is(err, retry.ErrApplied) {
+		return billingError("PayPaymentOrder", nil, err)
+	}
+
+	if err == nil && resp.StatusCode != http.StatusCreated {
+		return billingError("PayPaymentOrder", resp, nil)
 	}
+	return nil
+}
+
+// paymentID gets the provider payment ID of the provided order.
+func (p PaymentProvider) paymentID(po *paymentOrder) string {
+	if p.PaymentID == "" {
+		return po.TransactionID
+	}
+	return strings.NewReplacer("{tid}", po.TransactionID, "{idn}", po.SubscriberID).Replace(p.PaymentID)
+}
 
-	var clients []clientRef
-	resp, err := c.do(req, &clients)
+// paymentTime gets the provided time in the time zone of the payments.
+func (p PaymentProvider) paymentTime(t time.Time) time.Time {
+	loc, err := time.LoadLocation(p.PaymentTime)
 	if err != nil {
-		return nil, fmt.Errorf("could not process get subscriber duties request due: %v", err)
+		// the time zone is validated with the configuration, so this is not expected
+		loc = time.UTC
 	}
+	return t.In(loc)
+}
+
+// newPaymentRequest creates the request for the payment of the provided order. The payment
+// is allocated to the invoices of the order, unless automatic allocation is configured.
+func newPaymentRequest(po *paymentOrder, provider PaymentProvider) *paymentRequest {
+	clientID, _ := strconv.Atoi(po.ClientID)
+	amount, _ := strconv.ParseFloat(po.Amount, 64)
+	req := &paymentRequest{
+		ClientID:          clientID,
+		MethodID:          provider.MethodID,
+		Amount:            amount,
+		Note:              fmt.Sprintf("ePay TID: %s, IDN: %s", po.TransactionID, po.SubscriberID),
+		ProviderName:      provider.Name,
+		ProviderPaymentID: provider.paymentID(po),
+	}
+
+	// the payment is posted with the time when it was confirmed by ePay, which could
+	// be before the time of the posting when the confirmation is retried
+	if !po.PaidAt.IsZero() {
+		paidAt := &jsonDateTime{provider.paymentTime(po.PaidAt)}
+		req.CreatedDate = paidAt
+		req.ProviderPaymentTime = paidAt
+		req.Note += ", paid at " + paidAt.Format("2006-01-02 15:04:05 -0700")
+	}
+
+	if provider.Allocation == epay.UCRMAllocationAuto || len(po.InvoiceIDs) == 0 {
+		req.ApplyToInvoicesAutomatically = true
+		return req
+	}
+
+	for i, id := range po.InvoiceIDs {
+		invoiceID, _ := strconv.Atoi(id)
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("got bad response due: %v", err)
+		// orders created before the allocation are not keeping the amounts of the invoices
+		if len(po.InvoiceAmounts) != len(po.InvoiceIDs) {
+			req.InvoiceIDs = append(req.InvoiceIDs, invoiceID)
+			continue
+		}
+		invoiceAmount, _ := strconv.ParseFloat(po.InvoiceAmounts[i], 64)
+		// invoices which are covered by the credit of the client are not paid
+		if invoiceAmount == 0 {
+			continue
+		}
+		req.InvoiceIDs = append(req.InvoiceIDs, invoiceID)
+		req.PaymentCovers = append(req.PaymentCovers, paymentCover{InvoiceID: invoiceID, Amount: invoiceAmount})
 	}
-	if len(clients) == 0 {
-		return nil, epay.ErrSubscriberNotFound
+	return req
+}
+
+// paymentExists checks whether the payment with the provided provider payment ID was registered for
+// the client of the order. The payments of the client are paged from the latest one until the payments
+// which were created before the order, as the payment is created with the payment time of ePay.
+func (c *client) paymentExists(ctx context.Context, po *paymentOrder, paymentID string) (bool, error) {
+	var since time.Time
+	if !po.CreatedAt.IsZero() {
+		since = po.CreatedAt.Add(-paymentTimeSkew)
 	}
 
-	return &clients[0], nil
+	for offset := 0; ; offset += paymentsPageSize {
+		params := url.Values{}
+		params.Add("clientId", po.ClientID)
+		params.Add("limit", strconv.Itoa(paymentsPageSize))
+		params.Add("offset", strconv.Itoa(offset))
+		params.Add("order", "createdDate")
+		params.Add("direction", "DESC")
+
+		req, err := c.newRequest(ctx, "GET", "/api/v1.0/payments", params)
+		if err != nil {
+			return false, fmt.Errorf("could not create request due: %v", err)
+		}
+
+		var payments []payment
+		resp, err := c.do(req, &payments)
+		if err != nil {
+			return false, billingError("GetPayments", nil, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return false, billingError("GetPayments", resp, nil)
+		}
+
+		for _, p := range payments {
+			if p.ProviderPaymentID == paymentID && p.ProviderName == c.paymentProvider.Name {
+				return true, nil
+			}
+		}
+		if len(payments) < paymentsPageSize {
+			return false, nil
+		}
+		if last := payments[len(payments)-1].CreatedDate; !last.IsZero() && last.Before(since) {
+			return false, nil
+		}
+	}
 }
 
 func (c *client) newRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
@@ -260,7 +538,16 @@ func (c *client) newRequest(ctx context.Context, method, path string, body inter
 }
 
 func (c *client) do(req *http.Request, v interface{}) (*http.Response, error) {
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
+	return decode(resp, err, v)
+}
+
+func (c *client) doVerified(req *http.Request, verify retry.Verifier, v interface{}) (*http.Response, error) {
+	resp, err := c.httpClient.DoVerified(req, verify)
+	return decode(resp, err, v)
+}
+
+func decode(resp *http.Response, err error, v interface{}) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
@@ -272,15 +559,39 @@ func (c *client) do(req *http.Request, v interface{}) (*http.Response, error) {
 	return resp, err
 }
 
+// billingError creates a new BillingError of the operation and closes the body of the response.
+func billingError(op string, resp *http.Response, err error) *epay.BillingError {
+	be := epay.NewBillingError(backend, op, resp, err)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return be
+}
+
+// orderError converts the error of loading of a payment order. Missing orders are
+// reported as epay.ErrPaymentOrderNotFound and the failures of datastore are wrapped.
+func orderError(err error) error {
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return epay.ErrPaymentOrderNotFound
+	}
+	return fmt.Errorf("could not load payment order due: %w", err)
+}
+
 type clientRef struct {
-	ID          int    `json:"id"`
-	FirstName   string `json:"firstName"`
-	LastName    string `json:"lastName"`
-	CompanyName string `json:"companyName"`
+	ID             int     `json:"id"`
+	FirstName      string  `json:"firstName"`
+	LastName       string  `json:"lastName"`
+	CompanyName    string  `json:"companyName"`
+	OrganizationID int     `json:"organizationId"`
+	AccountBalance float64 `json:"accountBalance"`
+	AccountCredit  float64 `json:"accountCredit"`
 }
 
 type invoice struct {
 	ID                int           `json:"id"`
+	Number            string        `json:"number"`
+	DueDate           ucrmTime      `json:"dueDate"`
+	CurrencyCode      string        `json:"currencyCode"`
 	Total             float64       `json:"total"`
 	AmountPaid        float64       `json:"amountPaid"`
 	ClientFirstName   string        `json:"clientFirstName"`
@@ -289,10 +600,6 @@ type invoice struct {
 	Items             []invoiceItem `json:"items"`
 }
 
-type invoiceItem struct {
-	Label string `json:"label"`
-}
-
 type paymentOrder struct {
 	SubscriberID  string    `datastore:"subscriberId,noindex"`
 	CustomerName  string    `datastore:"customerName,noindex"`
@@ -302,16 +609,50 @@ type paymentOrder struct {
 	CreatedAt     time.Time `datastore:"createdOn,noindex"`
 	ProcessedOn   time.Time `datastore:"processedOn,omitempty"`
 	InvoiceIDs    []string  `datastore:"invoiceIds,noindex"`
+
+	// InvoiceAmounts are the remaining amounts of the invoices at the creation of the order.
+	InvoiceAmounts []string `datastore:"invoiceAmounts,noindex"`
+
+	// Items are the names of the invoice items at the creation of the order and ItemDetails
+	// are the items with all of their details encoded as JSON.
+	Items       []string `datastore:"items,noindex"`
+	ItemDetails string   `datastore:"itemDetails,noindex"`
+
+	// State is the state of the payment and PayingSince is the time when the payment was started.
+	State       string    `datastore:"state,noindex"`
+	PayingSince time.Time `datastore:"payingSince,noindex,omitempty"`
+
+	// PaidAt is the time of the first confirmation of the payment by ePay.
+	PaidAt time.Time `datastore:"paidAt,noindex,omitempty"`
 }
 
 type paymentRequest struct {
-	ClientID          int     `json:"clientId"`
-	MethodID          string  `json:"methodId"`
-	Amount            float64 `json:"amount"`
-	ProviderName      string  `json:"providerName"`
-	ProviderPaymentID string  `json:"providerPaymentId"`
+	ClientID                     int            `json:"clientId"`
+	MethodID                     string         `json:"methodId"`
+	Amount                       float64        `json:"amount"`
+	Note                         string         `json:"note,omitempty"`
+	ProviderName                 string         `json:"providerName"`
+	ProviderPaymentID            string         `json:"providerPaymentId"`
+	ProviderPaymentTime          *jsonDateTime  `json:"providerPaymentTime,omitempty"`
+	CreatedDate                  *jsonDateTime  `json:"createdDate,omitempty"`
+	ApplyToInvoicesAutomatically bool           `json:"applyToInvoicesAutomatically"`
+	InvoiceIDs                   []int          `json:"invoiceIds,omitempty"`
+	PaymentCovers                []paymentCover `json:"paymentCovers,omitempty"`
+}
+
+// paymentCover is the part of the payment which is allocated to a single invoice.
+type paymentCover struct {
+	InvoiceID int     `json:"invoiceId"`
+	Amount    float64 `json:"amount"`
 }
 
 type paymentResponse struct {
 	ID int `json:"id"`
 }
+
+type payment struct {
+	ID                int      `json:"id"`
+	ProviderName      string   `json:"providerName"`
+	ProviderPaymentID string   `json:"providerPaymentId"`
+	CreatedDate       ucrmTime `json:"createdDate"`
+}
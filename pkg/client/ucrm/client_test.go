@@ -3,6 +3,7 @@ package ucrm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -25,7 +26,7 @@ func TestSusbscriberNotFound(t *testing.T) {
 
 	client := NewClient(baseURL, "testing-key", nil, PaymentProvider{})
 	_, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
-	if err != epay.ErrSubscriberNotFound {
+	if !errors.Is(err, epay.ErrSubscriberNotFound) {
 		t.Fatalf("expected subscriber not found but got: %v", err)
 	}
 }
@@ -76,7 +77,7 @@ func TestResidentialSubscriberDuties(t *testing.T) {
 		CustomerName: "John Smith",
 		CustomerRef:  "708",
 		DutyAmount:   epay.Amount{Value: "20.00"},
-		Items:        []epay.Item{epay.Item{Name: "service 1 04/2020"}},
+		Items:        []epay.Item{epay.Item{Name: "service 1 04/2020", Amount: epay.Amount{Value: "0.00"}, Price: "0.00"}},
 		DocumentIDs:  []string{"101"},
 	}
 	if !reflect.DeepEqual(resp, serverResponse) {
@@ -157,9 +158,9 @@ func TestGetDutiesWhenMultipleInvoices(t *testing.T) {
 		DutyAmount:   epay.Amount{Value: "35.43"},
 		DocumentIDs:  []string{"101", "102", "103"},
 		Items: []epay.Item{
-			epay.Item{Name: "service 1 04/2020"},
-			epay.Item{Name: "service 1 05/2020"},
-			epay.Item{Name: "service 1 06/2020"},
+			epay.Item{Name: "service 1 04/2020", Amount: epay.Amount{Value: "0.00"}, Price: "0.00"},
+			epay.Item{Name: "service 1 05/2020", Amount: epay.Amount{Value: "0.00"}, Price: "0.00"},
+			epay.Item{Name: "service 1 06/2020", Amount: epay.Amount{Value: "0.00"}, Price: "0.00"},
 		},
 	}
 
@@ -216,3 +217,24 @@ func jsonReply(w http.ResponseWriter, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonVal)
 }
+
+func TestSubscriberDutiesWhenClientsAreNotAvailable(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/clients", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	baseURL, _ := url.Parse(ts.URL)
+
+	client := NewClient(baseURL, "testing-key", nil, PaymentProvider{})
+	_, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
+	if errors.Is(err, epay.ErrSubscriberNotFound) {
+		t.Fatalf("expected failure of the backend but got: %v", err)
+	}
+	if !epay.IsRetryable(err) {
+		t.Errorf("expected: retryable error, got: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package ucrm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// netDuties nets the credit of the client against the remaining amounts of the invoices
+// according to the provided policy. The amounts are in coins. The net amount is allocated
+// to the invoices in their order and the allocations are returned with the applied credit.
+func netDuties(remaining []int, ref *clientRef, policy string) ([]int, int) {
+	gross := 0
+	for _, r := range remaining {
+		gross += r
+	}
+
+	net := gross
+	switch policy {
+	case epay.UCRMCreditAccount:
+		net = gross - toCoins(ref.AccountCredit)
+	case epay.UCRMCreditBalance:
+		// the balance of the client is negative when it has outstanding duties
+		net = -toCoins(ref.AccountBalance)
+	}
+	if net < 0 {
+		net = 0
+	}
+	if net > gross {
+		net = gross
+	}
+
+	allocated := make([]int, len(remaining))
+	left := net
+	for i, r := range remaining {
+		if r > left {
+			r = left
+		}
+		if r < 0 {
+			r = 0
+		}
+		allocated[i] = r
+		left -= r
+	}
+	return allocated, gross - net
+}
+
+// creditItem creates the item which is stating the credit applied to the duties.
+func creditItem(credit int) epay.Item {
+	return epay.Item{Name: fmt.Sprintf("Приспаднат кредит: %s", formatCoins(credit))}
+}
+
+func toCoins(amount float64) int {
+	return int(math.Round(amount * 100))
+}
+
+func formatCoins(coins int) string {
+	return fmt.Sprintf("%.2f", float64(coins)/100)
+}
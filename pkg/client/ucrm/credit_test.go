@@ -0,0 +1,76 @@
+package ucrm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/clouway/go-epay/pkg/client/retry"
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNetDuties(t *testing.T) {
+	cases := []struct {
+		name          string
+		remaining     []int
+		ref           clientRef
+		policy        string
+		wantAllocated []int
+		wantCredit    int
+	}{
+		{"no netting", []int{2000, 1200}, clientRef{AccountCredit: 5}, epay.UCRMCreditNone, []int{2000, 1200}, 0},
+		{"credit", []int{2000, 1200}, clientRef{AccountCredit: 5}, epay.UCRMCreditAccount, []int{2000, 700}, 500},
+		{"credit covering an invoice", []int{2000, 1200}, clientRef{AccountCredit: 15.5}, epay.UCRMCreditAccount, []int{1650, 0}, 1550},
+		{"fully covered by credit", []int{2000, 1200}, clientRef{AccountCredit: 40}, epay.UCRMCreditAccount, []int{0, 0}, 3200},
+		{"balance", []int{2000, 1200}, clientRef{AccountBalance: -25.99}, epay.UCRMCreditBalance, []int{2000, 599}, 601},
+		{"balance above invoices", []int{2000}, clientRef{AccountBalance: -50}, epay.UCRMCreditBalance, []int{2000}, 0},
+		{"positive balance", []int{2000}, clientRef{AccountBalance: 3}, epay.UCRMCreditBalance, []int{0}, 2000},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			allocated, credit := netDuties(c.remaining, &c.ref, c.policy)
+			if diff := cmp.Diff(c.wantAllocated, allocated); diff != "" {
+				t.Errorf("unexpected allocations (-want +got): %s", diff)
+			}
+			if credit != c.wantCredit {
+				t.Errorf("expected: credit %d, got: %d", c.wantCredit, credit)
+			}
+		})
+	}
+}
+
+func TestGetDutiesCoveredByCredit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/clients", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 708, "firstName": "John", "lastName": "Smith", "accountCredit": 30.0, "accountBalance": 10.0}]`))
+	})
+	mux.HandleFunc("/api/v1.0/invoices", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 101, "total": 20.0, "amountPaid": 0.0, "items": [{"label": "service 1 04/2020"}]}]`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	selection := DefaultInvoiceSelection
+	selection.CreditNetting = epay.UCRMCreditAccount
+	client := NewClientWithOptions(baseURL, "testing-key", nil, PaymentProvider{}, Options{Invoices: selection, Retry: retry.DefaultOptions})
+	duties, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
+	if err != nil {
+		t.Fatalf("unable to retrieve subscriber duties due: %v", err)
+	}
+
+	want := &epay.SubscriberDuties{
+		CustomerName: "John Smith",
+		CustomerRef:  "708",
+		DutyAmount:   epay.Amount{Value: "0.00"},
+		DocumentIDs:  []string{"101"},
+		Items:        []epay.Item{{Name: "service 1 04/2020", Amount: epay.Amount{Value: "0.00"}, Price: "0.00"}, {Name: "Приспаднат кредит: 20.00"}},
+	}
+	if diff := cmp.Diff(want, duties); diff != "" {
+		t.Errorf("unexpected duties (-want +got): %s", diff)
+	}
+}
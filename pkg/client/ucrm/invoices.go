@@ -0,0 +1,134 @@
+package ucrm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// InvoiceSelection is selecting the invoices which are included in the duties of the subscriber.
+type InvoiceSelection struct {
+	// Statuses are the UCRM statuses of the included invoices.
+	Statuses []int
+
+	// Types are the types of the included invoices. Invoices of all types are included when it's empty.
+	Types []string
+
+	// PageSize is the number of invoices which are fetched with a single request.
+	PageSize int
+
+	// Concurrency is the number of pages which are fetched concurrently.
+	Concurrency int
+
+	// CreditNetting is the policy of netting the credit of the client against the invoices,
+	// which is one of epay.UCRMCreditNone, epay.UCRMCreditAccount or epay.UCRMCreditBalance.
+	CreditNetting string
+}
+
+// DefaultInvoiceSelection is selecting the unpaid and partially paid invoices of all types.
+var DefaultInvoiceSelection = InvoiceSelection{
+	Statuses:    []int{epay.UCRMInvoiceStatusUnpaid, epay.UCRMInvoiceStatusPartiallyPaid},
+	PageSize:    100,
+	Concurrency: 1,
+}
+
+// listInvoices gets all invoices of the client which are matching the invoice selection. The
+// pages are fetched in batches of the configured concurrency until a page which is not full.
+func (c *client) listInvoices(ctx context.Context, clientID string) ([]invoice, error) {
+	sel := c.invoices
+	if sel.PageSize <= 0 {
+		sel.PageSize = DefaultInvoiceSelection.PageSize
+	}
+	if sel.Concurrency <= 0 {
+		sel.Concurrency = DefaultInvoiceSelection.Concurrency
+	}
+
+	var invoices []invoice
+	seen := make(map[int]bool)
+	for offset := 0; ; offset += sel.PageSize * sel.Concurrency {
+		pages, err := c.fetchInvoicePages(ctx, clientID, sel, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, page := range pages {
+			added := 0
+			for _, inv := range page {
+				if seen[inv.ID] {
+					continue
+				}
+				seen[inv.ID] = true
+				invoices = append(invoices, inv)
+				added++
+			}
+			// the last page is reached or the backend is not applying the offset
+			if len(page) < sel.PageSize || added == 0 {
+				return invoices, nil
+			}
+		}
+	}
+}
+
+// fetchInvoicePages fetches the pages of the batch which is starting at the provided offset.
+func (c *client) fetchInvoicePages(ctx context.Context, clientID string, sel InvoiceSelection, offset int) ([][]invoice, error) {
+	pages := make([][]invoice, sel.Concurrency)
+	errs := make([]error, sel.Concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < sel.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pages[i], errs[i] = c.fetchInvoicePage(ctx, clientID, sel, offset+i*sel.PageSize)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pages, nil
+}
+
+func (c *client) fetchInvoicePage(ctx context.Context, clientID string, sel InvoiceSelection, offset int) ([]invoice, error) {
+	params := url.Values{}
+	params.Add("clientId", clientID)
+	for i, status := range sel.Statuses {
+		params.Add(fmt.Sprintf("statuses[%d]", i), strconv.Itoa(status))
+	}
+	if len(sel.Types) == 1 {
+		proforma := "0"
+		if sel.Types[0] == epay.UCRMInvoiceTypeProforma {
+			proforma = "1"
+		}
+		params.Add("proforma", proforma)
+	}
+	params.Add("order", "createdDate")
+	params.Add("direction", "ASC")
+	params.Add("limit", strconv.Itoa(sel.PageSize))
+	params.Add("offset", strconv.Itoa(offset))
+
+	req, err := c.newRequest(ctx, "GET", "/api/v1.0/invoices", params)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request due: %v", err)
+	}
+	var page []invoice
+	resp, err := c.do(req, &page)
+	if err != nil {
+		return nil, billingError("GetInvoices", nil, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, billingError("GetInvoices", resp, epay.ErrSubscriberNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, billingError("GetInvoices", resp, nil)
+	}
+	return page, nil
+}
@@ -0,0 +1,112 @@
+package ucrm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/clouway/go-epay/pkg/client/retry"
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+func TestGetDutiesOfAllInvoicePages(t *testing.T) {
+	cases := []struct {
+		name      string
+		selection InvoiceSelection
+		requests  int32
+	}{
+		{"sequential", InvoiceSelection{Statuses: []int{1, 2}, PageSize: 100, Concurrency: 1}, 3},
+		{"concurrent", InvoiceSelection{Statuses: []int{1, 2}, PageSize: 100, Concurrency: 2}, 4},
+		{"exact pages", InvoiceSelection{Statuses: []int{1, 2}, PageSize: 125, Concurrency: 1}, 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var requests int32
+			ts := newInvoicesServer(t, 250, func(r *http.Request) { atomic.AddInt32(&requests, 1) })
+			defer ts.Close()
+			baseURL, _ := url.Parse(ts.URL)
+
+			client := NewClientWithOptions(baseURL, "testing-key", nil, PaymentProvider{}, Options{Invoices: c.selection, Retry: retry.DefaultOptions})
+			duties, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
+			if err != nil {
+				t.Fatalf("unable to retrieve subscriber duties due: %v", err)
+			}
+
+			if duties.DutyAmount.Value != "250.00" || len(duties.DocumentIDs) != 250 {
+				t.Errorf("expected: 250 invoices with amount 250.00, got: %d with amount %s", len(duties.DocumentIDs), duties.DutyAmount.Value)
+			}
+			if got := atomic.LoadInt32(&requests); got != c.requests {
+				t.Errorf("expected: %d requests of invoices, got: %d", c.requests, got)
+			}
+		})
+	}
+}
+
+func TestGetDutiesWithInvoiceFilters(t *testing.T) {
+	var query url.Values
+	ts := newInvoicesServer(t, 1, func(r *http.Request) { query = r.URL.Query() })
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	selection := InvoiceSelection{Statuses: []int{0, 1}, Types: []string{epay.UCRMInvoiceTypeProforma}, PageSize: 10, Concurrency: 1}
+	client := NewClientWithOptions(baseURL, "testing-key", nil, PaymentProvider{}, Options{Invoices: selection, Retry: retry.DefaultOptions})
+	if _, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::"); err != nil {
+		t.Fatalf("unable to retrieve subscriber duties due: %v", err)
+	}
+
+	want := map[string]string{"statuses[0]": "0", "statuses[1]": "1", "proforma": "1", "limit": "10", "offset": "0"}
+	for k, v := range want {
+		if got := query.Get(k); got != v {
+			t.Errorf("expected: %s=%s, got: %s", k, v, got)
+		}
+	}
+}
+
+func TestGetDutiesWhenOffsetIsIgnored(t *testing.T) {
+	ts := newInvoicesServer(t, 2, func(r *http.Request) {
+		q := r.URL.Query()
+		q.Set("offset", "0")
+		r.URL.RawQuery = q.Encode()
+	})
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	selection := InvoiceSelection{Statuses: []int{1}, PageSize: 2, Concurrency: 1}
+	client := NewClientWithOptions(baseURL, "testing-key", nil, PaymentProvider{}, Options{Invoices: selection, Retry: retry.DefaultOptions})
+	duties, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
+	if err != nil {
+		t.Fatalf("unable to retrieve subscriber duties due: %v", err)
+	}
+	if len(duties.DocumentIDs) != 2 {
+		t.Errorf("expected: 2 invoices, got: %v", duties.DocumentIDs)
+	}
+}
+
+// newInvoicesServer creates a server of a single client with the provided number of invoices,
+// each with total of 1.00. The inspect func is called for each request of invoices.
+func newInvoicesServer(t *testing.T, count int, inspect func(r *http.Request)) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/clients", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 708, "firstName": "John", "lastName": "Smith"}]`))
+	})
+	mux.HandleFunc("/api/v1.0/invoices", func(w http.ResponseWriter, r *http.Request) {
+		inspect(r)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+		page := []invoice{}
+		for i := offset; i < count && i < offset+limit; i++ {
+			page = append(page, invoice{ID: i + 1, Total: 1})
+		}
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			t.Errorf("could not encode invoices due: %v", err)
+		}
+	})
+	return httptest.NewServer(mux)
+}
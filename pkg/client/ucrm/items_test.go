@@ -0,0 +1,163 @@
+package ucrm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/client/retry"
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetDutiesWithItemDetails(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/clients", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 708, "firstName": "John", "lastName": "Smith"}]`))
+	})
+	mux.HandleFunc("/api/v1.0/invoices", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{
+			"id": 101,
+			"number": "2020000101",
+			"dueDate": "2020-05-15T00:00:00+0000",
+			"currencyCode": "BGN",
+			"total": 24.0,
+			"amountPaid": 0.0,
+			"items": [
+				{
+					"label": "Internet 50Mbps",
+					"price": 10.0,
+					"quantity": 2,
+					"total": 20.0,
+					"tax1Id": 1,
+					"invoicedFrom": "2020-04-01T00:00:00+0000",
+					"invoicedTo": "2020-04-30T00:00:00+0000"
+				},
+				{"label": "Installation", "price": 8.0, "quantity": 0.5, "total": 4.0, "tax1Id": null}
+			]
+		}]`))
+	})
+	mux.HandleFunc("/api/v1.0/taxes", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 1, "name": "VAT", "rate": 20.0}]`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	client := NewClient(baseURL, "testing-key", nil, PaymentProvider{})
+	duties, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
+	if err != nil {
+		t.Fatalf("unable to retrieve subscriber duties due: %v", err)
+	}
+
+	dueDate := time.Date(2020, 5, 15, 0, 0, 0, 0, time.UTC)
+	want := []epay.Item{
+		{
+			Name:          "Internet 50Mbps",
+			StartDate:     time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:       time.Date(2020, 4, 30, 0, 0, 0, 0, time.UTC),
+			Amount:        epay.Amount{Value: "20.00", Currency: "BGN"},
+			Vat:           20,
+			Price:         "10.00",
+			Quantity:      2,
+			InvoiceNumber: "2020000101",
+			DueDate:       dueDate,
+		},
+		{
+			Name:          "Installation",
+			Amount:        epay.Amount{Value: "4.00", Currency: "BGN"},
+			Price:         "8.00",
+			Quantity:      0.5,
+			InvoiceNumber: "2020000101",
+			DueDate:       dueDate,
+		},
+	}
+	opt := cmp.Comparer(func(a, b time.Time) bool { return a.Equal(b) })
+	if diff := cmp.Diff(want, duties.Items, opt); diff != "" {
+		t.Errorf("unexpected items (-want +got): %s", diff)
+	}
+}
+
+func TestPaymentOrderItems(t *testing.T) {
+	items := []epay.Item{{Name: "Internet", Amount: epay.Amount{Value: "20.00", Currency: "BGN"}, Vat: 20, Price: "10.00", Quantity: 2, InvoiceNumber: "1"}}
+
+	po := &paymentOrder{}
+	if err := po.setItems(items); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(items, po.items()); diff != "" {
+		t.Errorf("unexpected items (-want +got): %s", diff)
+	}
+
+	legacy := &paymentOrder{Items: []string{"Internet"}}
+	if diff := cmp.Diff([]epay.Item{{Name: "Internet"}}, legacy.items()); diff != "" {
+		t.Errorf("unexpected items of legacy order (-want +got): %s", diff)
+	}
+}
+
+// newTaxesServer creates a server which responds to the requests for taxes with the provided status,
+// where the tax 2 is added after the first request. The requests are counted in the provided counter.
+func newTaxesServer(status int, requests *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/taxes", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(requests, 1)
+		w.WriteHeader(status)
+		if n == 1 {
+			w.Write([]byte(`[{"id": 1, "rate": 20.0}]`))
+			return
+		}
+		w.Write([]byte(`[{"id": 1, "rate": 20.0}, {"id": 2, "rate": 9.0}]`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestTaxRatesAreCached(t *testing.T) {
+	var requests int32
+	ts := newTaxesServer(http.StatusOK, &requests)
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	opts := Options{Invoices: DefaultInvoiceSelection, Lookup: DefaultSubscriberLookup, Retry: retry.DefaultOptions, Taxes: NewTaxCache(), TaxesTTL: time.Hour}
+	uc := NewClientWithOptions(baseURL, "testing-key", nil, PaymentProvider{}, opts).(*client)
+
+	tax1, tax2 := 1, 2
+	taxed := []invoice{{Items: []invoiceItem{{Tax1ID: &tax1}}}}
+	for i := 0; i < 2; i++ {
+		if _, err := uc.taxRates(context.Background(), taxed); err != nil {
+			t.Fatalf("unable to get tax rates due: %v", err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected: 1 request, got: %d", requests)
+	}
+
+	// the taxes are fetched again when any of them is not cached
+	rates, err := uc.taxRates(context.Background(), []invoice{{Items: []invoiceItem{{Tax1ID: &tax1, Tax2ID: &tax2}}}})
+	if err != nil {
+		t.Fatalf("unable to get tax rates due: %v", err)
+	}
+	if diff := cmp.Diff(map[int]float64{1: 20, 2: 9}, rates); diff != "" {
+		t.Errorf("unexpected rates (-want +got): %s", diff)
+	}
+	if requests != 2 {
+		t.Errorf("expected: 2 requests, got: %d", requests)
+	}
+}
+
+func TestTaxRatesFailure(t *testing.T) {
+	var requests int32
+	ts := newTaxesServer(http.StatusForbidden, &requests)
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	uc := NewClient(baseURL, "testing-key", nil, PaymentProvider{}).(*client)
+
+	tax1 := 1
+	if _, err := uc.taxRates(context.Background(), []invoice{{Items: []invoiceItem{{Tax1ID: &tax1}}}}); err == nil {
+		t.Errorf("expected: error, got: nil")
+	}
+}
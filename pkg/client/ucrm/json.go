@@ -20,6 +20,5 @@ type jsonDateTime struct {
 }
 
 func (t jsonDateTime) MarshalJSON() ([]byte, error) {
-	date := t.Format("2006-01-02T15:04:05") + "+0000"
-	return json.Marshal(date)
+	return json.Marshal(t.Format("2006-01-02T15:04:05-0700"))
 }
@@ -0,0 +1,263 @@
+package ucrm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// maxCachedClients is the number of cached clients above which the expired ones are removed.
+const maxCachedClients = 10000
+
+// SubscriberLookup is the lookup of the UCRM client of the subscriber.
+type SubscriberLookup struct {
+	// Strategies are the strategies which are tried in order until the client is found. Each
+	// of them is one of epay.UCRMLookupUserIdent, epay.UCRMLookupClientID, epay.UCRMLookupServiceID
+	// or epay.UCRMLookupCustomAttribute followed by ':' and the key of the attribute.
+	Strategies []string
+
+	// Ambiguous is the handling of subscribers which match several clients, which is one
+	// of epay.UCRMAmbiguousNotFound or epay.UCRMAmbiguousError.
+	Ambiguous string
+
+	// Cache is the cache of the found clients, which are kept for CacheTTL. Clients are
+	// not cached when it's nil. The balance of the clients is not cached and is read for
+	// each request when it's netted against the invoices.
+	Cache    *ClientCache
+	CacheTTL time.Duration
+}
+
+// DefaultSubscriberLookup is finding the subscribers by the user ident of the clients.
+var DefaultSubscriberLookup = SubscriberLookup{
+	Strategies: []string{epay.UCRMLookupUserIdent},
+	Ambiguous:  epay.UCRMAmbiguousNotFound,
+}
+
+// ClientCache is a cache of the UCRM clients of the subscribers.
+type ClientCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedClient
+	now     func() time.Time
+}
+
+type cachedClient struct {
+	ref     clientRef
+	expires time.Time
+}
+
+// NewClientCache creates a new empty cache of clients.
+func NewClientCache() *ClientCache {
+	return &ClientCache{entries: make(map[string]cachedClient), now: time.Now}
+}
+
+func (c *ClientCache) get(key string) (*clientRef, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	ref := e.ref
+	return &ref, true
+}
+
+func (c *ClientCache) put(key string, ref clientRef, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= maxCachedClients {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = cachedClient{ref: ref, expires: now.Add(ttl)}
+}
+
+// findClientID finds the client of the subscriber with the configured strategies. The
+// subscriber is not found when it matches several clients, unless this is reported as error.
+func (c *client) findClientID(ctx context.Context, subscriberID string) (*clientRef, error) {
+	lookup := c.lookup
+	strategies := lookup.Strategies
+	if len(strategies) == 0 {
+		strategies = DefaultSubscriberLookup.Strategies
+	}
+	orgs := epay.SplitList(c.paymentProvider.OrganizationID)
+
+	useCache := lookup.Cache != nil && lookup.CacheTTL > 0
+	key := strings.Join([]string{c.BaseURL.String(), strings.Join(strategies, ","), strings.Join(orgs, ","), subscriberID}, "|")
+	if useCache {
+		if ref, ok := lookup.Cache.get(key); ok {
+			if !c.netsCredit() {
+				return ref, nil
+			}
+			// the balance of the client is netted against the invoices, so it's read for each request
+			return c.getClientBalance(ctx, ref.ID)
+		}
+	}
+
+	for _, strategy := range strategies {
+		refs, err := c.lookupClients(ctx, strategy, subscriberID, orgs)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(refs) == 0 {
+			continue
+		}
+		if len(refs) > 1 {
+			log.WithContext(ctx).Printf("subscriber matches %d clients with lookup '%s'", len(refs), strategy)
+			if lookup.Ambiguous == epay.UCRMAmbiguousError {
+				return nil, &epay.BillingError{Backend: backend, Op: "GetClients", Err: epay.ErrSubscriberAmbiguous}
+			}
+			return nil, &epay.BillingError{Backend: backend, Op: "GetClients", Err: epay.ErrSubscriberNotFound}
+		}
+
+		if useCache {
+			cached := refs[0]
+			cached.AccountBalance, cached.AccountCredit = 0, 0
+			lookup.Cache.put(key, cached, lookup.CacheTTL)
+		}
+		return &refs[0], nil
+	}
+
+	return nil, &epay.BillingError{Backend: backend, Op: "GetClients", Err: epay.ErrSubscriberNotFound}
+}
+
+// netsCredit reports whether the account credit or balance of the client is netted against it's invoices.
+func (c *client) netsCredit() bool {
+	return c.invoices.CreditNetting != "" && c.invoices.CreditNetting != epay.UCRMCreditNone
+}
+
+// getClientBalance gets the client with the provided id together with it's current account balance
+// and credit. The subscriber is not found when the client is no longer existing.
+func (c *client) getClientBalance(ctx context.Context, clientID int) (*clientRef, error) {
+	var ref clientRef
+	found, err := c.get(ctx, "GetClient", "/api/v1.0/clients/"+strconv.Itoa(clientID), nil, &ref)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &epay.BillingError{Backend: backend, Op: "GetClient", Err: epay.ErrSubscriberNotFound}
+	}
+	return &ref, nil
+}
+
+// lookupClients gets the clients of the provided organizations which are matching the
+// subscriber with the provided strategy.
+func (c *client) lookupClients(ctx context.Context, strategy, subscriberID string, orgs []string) ([]clientRef, error) {
+	params := url.Values{}
+	switch {
+	case strategy == epay.UCRMLookupUserIdent:
+		params.Add("userIdent", subscriberID)
+		return c.getClients(ctx, params, orgs)
+
+	case strings.HasPrefix(strategy, epay.UCRMLookupCustomAttribute+":"):
+		params.Add("customAttributeKey", strings.TrimPrefix(strategy, epay.UCRMLookupCustomAttribute+":"))
+		params.Add("customAttributeValue", subscriberID)
+		return c.getClients(ctx, params, orgs)
+
+	case strategy == epay.UCRMLookupClientID:
+		if _, err := strconv.Atoi(subscriberID); err != nil {
+			return nil, nil
+		}
+		return c.getClient(ctx, subscriberID, orgs)
+
+	case strategy == epay.UCRMLookupServiceID:
+		if _, err := strconv.Atoi(subscriberID); err != nil {
+			return nil, nil
+		}
+		var service struct {
+			ClientID int `json:"clientId"`
+		}
+		found, err := c.get(ctx, "GetService", "/api/v1.0/clients/services/"+subscriberID, nil, &service)
+		if err != nil || !found {
+			return nil, err
+		}
+		return c.getClient(ctx, strconv.Itoa(service.ClientID), orgs)
+	}
+	return nil, fmt.Errorf("unknown subscriber lookup '%s'", strategy)
+}
+
+// getClients gets the clients which are matching the provided params. A single organization
+// is filtered by UCRM, while several organizations are filtered from all matching clients.
+func (c *client) getClients(ctx context.Context, params url.Values, orgs []string) ([]clientRef, error) {
+	if len(orgs) == 1 {
+		params.Add("organizationId", orgs[0])
+	}
+	var clients []clientRef
+	if _, err := c.get(ctx, "GetClients", "/api/v1.0/clients", params, &clients); err != nil {
+		return nil, err
+	}
+	if len(orgs) > 1 {
+		clients = inOrganizations(clients, orgs)
+	}
+	return clients, nil
+}
+
+func (c *client) getClient(ctx context.Context, clientID string, orgs []string) ([]clientRef, error) {
+	var ref clientRef
+	found, err := c.get(ctx, "GetClient", "/api/v1.0/clients/"+clientID, nil, &ref)
+	if err != nil || !found {
+		return nil, err
+	}
+	return inOrganizations([]clientRef{ref}, orgs), nil
+}
+
+// get gets the resource with the provided path. False is returned when the resource is not found.
+func (c *client) get(ctx context.Context, op, path string, params url.Values, v interface{}) (bool, error) {
+	var body interface{}
+	if params != nil {
+		body = params
+	}
+	req, err := c.newRequest(ctx, "GET", path, body)
+	if err != nil {
+		return false, fmt.Errorf("could not create request due: %v", err)
+	}
+	resp, err := c.do(req, v)
+	if err != nil {
+		return false, billingError(op, nil, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, billingError(op, resp, nil)
+	}
+	return true, nil
+}
+
+// inOrganizations filters the clients of the provided organizations. All clients are
+// returned when no organizations are provided.
+func inOrganizations(refs []clientRef, orgs []string) []clientRef {
+	if len(orgs) == 0 {
+		return refs
+	}
+	var filtered []clientRef
+	for _, ref := range refs {
+		for _, org := range orgs {
+			if strconv.Itoa(ref.OrganizationID) == org {
+				filtered = append(filtered, ref)
+				break
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,145 @@
+package ucrm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/client/retry"
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// newLookupServer creates a server with the clients 708 of organization 1 and 709 of organization 2,
+// which are sharing the user ident "shared". The requests for clients are counted in the provided counter.
+func newLookupServer(requests *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/clients", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		q := r.URL.Query()
+		switch {
+		case q.Get("userIdent") == "shared" && q.Get("organizationId") == "1":
+			w.Write([]byte(`[{"id": 708, "organizationId": 1}]`))
+		case q.Get("userIdent") == "shared":
+			w.Write([]byte(`[{"id": 708, "organizationId": 1}, {"id": 709, "organizationId": 2}]`))
+		case q.Get("customAttributeKey") == "contract" && q.Get("customAttributeValue") == "C-709":
+			w.Write([]byte(`[{"id": 709, "organizationId": 2}]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	})
+	mux.HandleFunc("/api/v1.0/clients/708", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.Write([]byte(`{"id": 708, "organizationId": 1, "accountCredit": 10}`))
+	})
+	mux.HandleFunc("/api/v1.0/clients/709", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.Write([]byte(`{"id": 709, "organizationId": 2}`))
+	})
+	mux.HandleFunc("/api/v1.0/clients/services/55", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 55, "clientId": 709}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestFindClientID(t *testing.T) {
+	cases := []struct {
+		name         string
+		subscriberID string
+		strategies   []string
+		orgs         string
+		ambiguous    string
+		wantID       int
+		wantErr      error
+	}{
+		{"by user ident of organization", "shared", []string{"userIdent"}, "1", "", 708, nil},
+		{"by client id", "709", []string{"userIdent", "clientId"}, "", "", 709, nil},
+		{"by service id", "55", []string{"serviceId"}, "", "", 709, nil},
+		{"by custom attribute", "C-709", []string{"userIdent", "customAttribute:contract"}, "", "", 709, nil},
+		{"client of other organization", "709", []string{"clientId"}, "1", "", 0, epay.ErrSubscriberNotFound},
+		{"several organizations", "shared", []string{"userIdent"}, "2,3", "", 709, nil},
+		{"ambiguous as not found", "shared", []string{"userIdent"}, "", "", 0, epay.ErrSubscriberNotFound},
+		{"ambiguous as error", "shared", []string{"userIdent"}, "1,2", epay.UCRMAmbiguousError, 0, epay.ErrSubscriberAmbiguous},
+		{"not found by any strategy", "unknown", []string{"userIdent", "clientId", "serviceId"}, "", "", 0, epay.ErrSubscriberNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var requests int32
+			ts := newLookupServer(&requests)
+			defer ts.Close()
+			baseURL, _ := url.Parse(ts.URL)
+
+			opts := Options{Invoices: DefaultInvoiceSelection, Lookup: SubscriberLookup{Strategies: c.strategies, Ambiguous: c.ambiguous}, Retry: retry.DefaultOptions}
+			uc := NewClientWithOptions(baseURL, "testing-key", nil, PaymentProvider{OrganizationID: c.orgs}, opts).(*client)
+
+			ref, err := uc.findClientID(context.Background(), c.subscriberID)
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("expected: %v, got: %v", c.wantErr, err)
+			}
+			if c.wantErr == nil && ref.ID != c.wantID {
+				t.Errorf("expected: client %d, got: %d", c.wantID, ref.ID)
+			}
+		})
+	}
+}
+
+func TestFindCachedClientID(t *testing.T) {
+	var requests int32
+	ts := newLookupServer(&requests)
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	now := time.Date(2020, 4, 1, 10, 0, 0, 0, time.UTC)
+	cache := NewClientCache()
+	cache.now = func() time.Time { return now }
+
+	lookup := SubscriberLookup{Strategies: []string{"clientId"}, Cache: cache, CacheTTL: 5 * time.Minute}
+	opts := Options{Invoices: DefaultInvoiceSelection, Lookup: lookup, Retry: retry.DefaultOptions}
+	uc := NewClientWithOptions(baseURL, "testing-key", nil, PaymentProvider{}, opts).(*client)
+
+	for _, elapsed := range []time.Duration{0, 4 * time.Minute, 5 * time.Minute} {
+		now = now.Add(elapsed)
+		if _, err := uc.findClientID(context.Background(), "708"); err != nil {
+			t.Fatalf("unable to find client due: %v", err)
+		}
+	}
+
+	// the second lookup is cached, while the third one is after the expiry of the client
+	if requests != 2 {
+		t.Errorf("expected: 2 requests, got: %d", requests)
+	}
+}
+
+func TestFindCachedClientIDWithCreditNetting(t *testing.T) {
+	var requests int32
+	ts := newLookupServer(&requests)
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	lookup := SubscriberLookup{Strategies: []string{"userIdent"}, Cache: NewClientCache(), CacheTTL: 5 * time.Minute}
+	invoices := DefaultInvoiceSelection
+	invoices.CreditNetting = epay.UCRMCreditAccount
+	opts := Options{Invoices: invoices, Lookup: lookup, Retry: retry.DefaultOptions}
+	uc := NewClientWithOptions(baseURL, "testing-key", nil, PaymentProvider{OrganizationID: "1"}, opts).(*client)
+
+	if _, err := uc.findClientID(context.Background(), "shared"); err != nil {
+		t.Fatalf("unable to find client due: %v", err)
+	}
+	ref, err := uc.findClientID(context.Background(), "shared")
+	if err != nil {
+		t.Fatalf("unable to find client due: %v", err)
+	}
+
+	// the cached client is read by it's id to get the current credit
+	if ref.ID != 708 || ref.AccountCredit != 10 {
+		t.Errorf("expected: client 708 with credit 10, got: %d with credit %v", ref.ID, ref.AccountCredit)
+	}
+	if requests != 2 {
+		t.Errorf("expected: 2 requests, got: %d", requests)
+	}
+}
@@ -0,0 +1,108 @@
+package ucrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// archiveKind is the kind of the archived payment orders.
+const archiveKind = "PaymentOrderArchive"
+
+// UnpaidOrder is a payment order which was created, but was not paid.
+type UnpaidOrder struct {
+	TransactionID string     `json:"transactionId"`
+	SubscriberID  string     `json:"subscriberId"`
+	ClientID      string     `json:"clientId"`
+	Amount        string     `json:"amount"`
+	State         string     `json:"state"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	PayingSince   *time.Time `json:"payingSince,omitempty"`
+}
+
+// ListUnpaidOrders lists the payment orders which were created before the provided time
+// and were not paid. Orders of which payment was started, but was not completed, are in
+// the paying state and should be checked in UCRM.
+func ListUnpaidOrders(ctx context.Context, dClient *datastore.Client, before time.Time) ([]UnpaidOrder, error) {
+	var orders []UnpaidOrder
+	err := forEachUnpaidOrder(ctx, dClient, before, func(k *datastore.Key, po *paymentOrder) error {
+		order := UnpaidOrder{
+			TransactionID: k.Name,
+			SubscriberID:  po.SubscriberID,
+			ClientID:      po.ClientID,
+			Amount:        po.Amount,
+			State:         "created",
+			CreatedAt:     po.CreatedAt,
+		}
+		if po.State == statePaying {
+			order.State = statePaying
+			order.PayingSince = &po.PayingSince
+		}
+		orders = append(orders, order)
+		return nil
+	})
+	return orders, err
+}
+
+// CleanupOrders deletes the payment orders which were created before the provided time and
+// of which payment was not started. The orders are moved to the archive when archive is set.
+// Orders in the paying state are kept, since their payment could be registered in UCRM.
+func CleanupOrders(ctx context.Context, dClient *datastore.Client, before time.Time, archive bool) (int, error) {
+	removed := 0
+	err := forEachUnpaidOrder(ctx, dClient, before, func(k *datastore.Key, _ *paymentOrder) error {
+		_, err := dClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			po := &paymentOrder{}
+			if err := tx.Get(k, po); err != nil {
+				return err
+			}
+			// the order could be paid after it was listed
+			if po.State != stateCreated || !po.ProcessedOn.IsZero() {
+				return errOrderInUse
+			}
+			if archive {
+				if _, err := tx.Put(datastore.NameKey(archiveKind, k.Name, nil), po); err != nil {
+					return err
+				}
+			}
+			return tx.Delete(k)
+		})
+		if errors.Is(err, errOrderInUse) || errors.Is(err, datastore.ErrNoSuchEntity) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not remove payment order '%s' due: %w", k.Name, err)
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// errOrderInUse is the error used when the order is paid during the cleanup.
+var errOrderInUse = errors.New("payment order is in use")
+
+// forEachUnpaidOrder calls the provided func with each unpaid payment order which was created before
+// the provided time. The creation time is not indexed, so all orders are scanned.
+func forEachUnpaidOrder(ctx context.Context, dClient *datastore.Client, before time.Time, f func(k *datastore.Key, po *paymentOrder) error) error {
+	it := dClient.Run(ctx, datastore.NewQuery(poKind))
+	for {
+		po := &paymentOrder{}
+		k, err := it.Next(po)
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not list payment orders due: %w", err)
+		}
+		if po.State == statePaid || !po.ProcessedOn.IsZero() || !po.CreatedAt.Before(before) {
+			continue
+		}
+		if err := f(k, po); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package ucrm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// States of the payment order.
+const (
+	stateCreated = ""
+	statePaying  = "paying"
+	statePaid    = "paid"
+)
+
+// payingLease is the time for which the payment of an order is reserved for a single
+// confirmation. Payments which are not completed in it are considered as crashed and
+// are taken over by the next confirmation.
+const payingLease = 2 * time.Minute
+
+// payTimeout is the time in which the payment of an order should be posted to UCRM. It's well
+// under the paying lease, so a slow confirmation is not taken over while it's still paying.
+const payTimeout = payingLease / 3
+
+// reuse checks whether the existing order could be returned for the new order of the
+// subscriber. Orders which were paid or which are of another subscriber are not replaced.
+func (po *paymentOrder) reuse(subscriberID string, requote bool) (bool, error) {
+	if po.State != stateCreated || !po.ProcessedOn.IsZero() || po.SubscriberID != subscriberID {
+		return false, epay.ErrPaymentOrderAlreadyExists
+	}
+	return !requote, nil
+}
+
+// setItems sets the items of the order.
+func (po *paymentOrder) setItems(items []epay.Item) error {
+	details, err := json.Marshal(items)
+	if err != nil {
+		return fmt.Errorf("could not encode items due: %v", err)
+	}
+	po.ItemDetails = string(details)
+	po.Items = make([]string, 0, len(items))
+	for _, item := range items {
+		po.Items = append(po.Items, item.Name)
+	}
+	return nil
+}
+
+// items gets the items of the order. Only the names are available for the orders
+// which were created before the details of the items were kept.
+func (po *paymentOrder) items() []epay.Item {
+	var items []epay.Item
+	if po.ItemDetails != "" && json.Unmarshal([]byte(po.ItemDetails), &items) == nil {
+		return items
+	}
+	items = make([]epay.Item, 0, len(po.Items))
+	for _, name := range po.Items {
+		items = append(items, epay.Item{Name: name})
+	}
+	return items
+}
+
+// expired checks whether the order has expired at the provided time.
+func (po *paymentOrder) expired(now time.Time, expiry time.Duration) bool {
+	return expiry > 0 && now.Sub(po.CreatedAt) >= expiry
+}
+
+// checkExpiry checks whether the expired order could be paid. An expired order is paid only
+// when the amount of the provided current quote is equal to it's amount, in which case the
+// order is allocated to the current invoices. Orders of which payment was started or completed
+// are not checked, since their payment could be already registered in UCRM.
+func (po *paymentOrder) checkExpiry(now time.Time, expiry time.Duration, current *paymentOrder) error {
+	if po.State != stateCreated || !po.ProcessedOn.IsZero() || !po.expired(now, expiry) {
+		return nil
+	}
+	if current == nil || current.Amount != po.Amount {
+		return epay.ErrPaymentOrderExpired
+	}
+	po.InvoiceIDs = current.InvoiceIDs
+	po.InvoiceAmounts = current.InvoiceAmounts
+	po.Items = current.Items
+	po.ItemDetails = current.ItemDetails
+	return nil
+}
+
+// startPayment moves the order to the paying state. The payment is rejected when the
+// order was already paid or it's paid by another confirmation of which lease is not expired.
+func (po *paymentOrder) startPayment(now time.Time) error {
+	if po.State == statePaid || !po.ProcessedOn.IsZero() {
+		return epay.ErrPaymentOrderAlreadyPaid
+	}
+	if po.State == statePaying && now.Sub(po.PayingSince) < payingLease {
+		return epay.ErrPaymentInProgress
+	}
+	po.State = statePaying
+	po.PayingSince = now
+	// the time of the first confirmation is kept when the payment is retried, unless
+	// the time of the payment is reported by ePay
+	if po.PaidAt.IsZero() {
+		po.PaidAt = now
+	}
+	return nil
+}
+
+// completePayment moves the order to the paid state.
+func (po *paymentOrder) completePayment(now time.Time) {
+	po.State = statePaid
+	po.ProcessedOn = now
+}
+
+// abortPayment moves the order back to the created state, so the payment could be retried.
+// Orders of which lease was taken by another confirmation are not changed.
+func (po *paymentOrder) abortPayment(since time.Time) {
+	if po.State == statePaying && po.PayingSince.Equal(since) {
+		po.State = stateCreated
+		po.PayingSince = time.Time{}
+	}
+}
+
+// updateOrder updates the payment order with the provided key in a transaction.
+func (c *client) updateOrder(ctx context.Context, k *datastore.Key, update func(po *paymentOrder) error) (*paymentOrder, error) {
+	po := &paymentOrder{}
+	_, err := c.dClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		*po = paymentOrder{}
+		if err := tx.Get(k, po); err != nil {
+			return orderError(err)
+		}
+		if err := update(po); err != nil {
+			return err
+		}
+		_, err := tx.Put(k, po)
+		return err
+	})
+	if err != nil {
+		var be *epay.BillingError
+		if errors.Is(err, epay.ErrPaymentOrderNotFound) || errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) ||
+			errors.Is(err, epay.ErrPaymentOrderExpired) || errors.Is(err, epay.ErrPaymentInProgress) || errors.As(err, &be) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("could not update payment order due: %w", err)
+	}
+	return po, nil
+}
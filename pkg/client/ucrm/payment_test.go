@@ -0,0 +1,351 @@
+package ucrm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/client/retry"
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewPaymentRequest(t *testing.T) {
+	// the payment was confirmed at 02:30 in Sofia, which is still 30 April in UTC
+	paidAt := time.Date(2020, 4, 30, 23, 30, 0, 0, time.UTC)
+	sofia, _ := time.LoadLocation("Europe/Sofia")
+
+	provider := PaymentProvider{MethodID: "::method::", Name: "epay", Allocation: epay.UCRMAllocationInvoices}
+	po := &paymentOrder{
+		ClientID:       "708",
+		SubscriberID:   "::idn::",
+		TransactionID:  "::tid::",
+		Amount:         "32.50",
+		InvoiceIDs:     []string{"101", "102"},
+		InvoiceAmounts: []string{"20.00", "12.50"},
+	}
+
+	cases := []struct {
+		name     string
+		po       paymentOrder
+		provider PaymentProvider
+		want     *paymentRequest
+	}{
+		{
+			name:     "allocated to invoices",
+			po:       *po,
+			provider: provider,
+			want: &paymentRequest{
+				ClientID: 708, MethodID: "::method::", Amount: 32.5, Note: "ePay TID: ::tid::, IDN: ::idn::", ProviderName: "epay", ProviderPaymentID: "::tid::",
+				InvoiceIDs:    []int{101, 102},
+				PaymentCovers: []paymentCover{{InvoiceID: 101, Amount: 20}, {InvoiceID: 102, Amount: 12.5}},
+			},
+		},
+		{
+			name:     "invoice covered by credit",
+			po:       paymentOrder{ClientID: "708", SubscriberID: "::idn::", TransactionID: "::tid::", Amount: "12.50", InvoiceIDs: []string{"101", "102"}, InvoiceAmounts: []string{"0.00", "12.50"}},
+			provider: provider,
+			want: &paymentRequest{
+				ClientID: 708, MethodID: "::method::", Amount: 12.5, Note: "ePay TID: ::tid::, IDN: ::idn::", ProviderName: "epay", ProviderPaymentID: "::tid::",
+				InvoiceIDs:    []int{102},
+				PaymentCovers: []paymentCover{{InvoiceID: 102, Amount: 12.5}},
+			},
+		},
+		{
+			name:     "order without invoice amounts",
+			po:       paymentOrder{ClientID: "708", SubscriberID: "::idn::", TransactionID: "::tid::", Amount: "32.50", InvoiceIDs: []string{"101", "102"}},
+			provider: provider,
+			want: &paymentRequest{
+				ClientID: 708, MethodID: "::method::", Amount: 32.5, Note: "ePay TID: ::tid::, IDN: ::idn::", ProviderName: "epay", ProviderPaymentID: "::tid::",
+				InvoiceIDs: []int{101, 102},
+			},
+		},
+		{
+			name:     "automatic allocation",
+			po:       *po,
+			provider: PaymentProvider{MethodID: "::method::", Name: "epay", Allocation: epay.UCRMAllocationAuto},
+			want: &paymentRequest{
+				ClientID: 708, MethodID: "::method::", Amount: 32.5, Note: "ePay TID: ::tid::, IDN: ::idn::", ProviderName: "epay", ProviderPaymentID: "::tid::",
+				ApplyToInvoicesAutomatically: true,
+			},
+		},
+		{
+			name:     "order without invoices",
+			po:       paymentOrder{ClientID: "708", SubscriberID: "::idn::", TransactionID: "::tid::", Amount: "10.00"},
+			provider: provider,
+			want: &paymentRequest{
+				ClientID: 708, MethodID: "::method::", Amount: 10, Note: "ePay TID: ::tid::, IDN: ::idn::", ProviderName: "epay", ProviderPaymentID: "::tid::",
+				ApplyToInvoicesAutomatically: true,
+			},
+		},
+		{
+			name:     "confirmed order with provider metadata",
+			po:       paymentOrder{ClientID: "708", SubscriberID: "::idn::", TransactionID: "::tid::", Amount: "10.00", PaidAt: paidAt},
+			provider: PaymentProvider{MethodID: "::method::", Name: "epay", PaymentID: "epay-{tid}", PaymentTime: "Europe/Sofia"},
+			want: &paymentRequest{
+				ClientID: 708, MethodID: "::method::", Amount: 10, Note: "ePay TID: ::tid::, IDN: ::idn::, paid at 2020-05-01 02:30:00 +0300", ProviderName: "epay", ProviderPaymentID: "epay-::tid::",
+				ProviderPaymentTime:          &jsonDateTime{paidAt.In(sofia)},
+				CreatedDate:                  &jsonDateTime{paidAt.In(sofia)},
+				ApplyToInvoicesAutomatically: true,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := newPaymentRequest(&c.po, c.provider)
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("unexpected payment request (-want +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestPaymentRequestTimes(t *testing.T) {
+	paidAt := time.Date(2020, 4, 30, 23, 30, 0, 0, time.UTC)
+	po := &paymentOrder{ClientID: "708", TransactionID: "::tid::", Amount: "10.00", PaidAt: paidAt}
+
+	b, err := json.Marshal(newPaymentRequest(po, PaymentProvider{PaymentTime: "Europe/Sofia"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got struct {
+		CreatedDate         string `json:"createdDate"`
+		ProviderPaymentTime string `json:"providerPaymentTime"`
+	}
+	json.Unmarshal(b, &got)
+	want := "2020-05-01T02:30:00+0300"
+	if got.CreatedDate != want || got.ProviderPaymentTime != want {
+		t.Errorf("expected: %s, got: %+v", want, got)
+	}
+}
+
+func TestStartPayment(t *testing.T) {
+	now := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name      string
+		po        paymentOrder
+		wantErr   error
+		wantState string
+	}{
+		{"created", paymentOrder{}, nil, statePaying},
+		{"paid", paymentOrder{State: statePaid, ProcessedOn: now}, epay.ErrPaymentOrderAlreadyPaid, statePaid},
+		{"processed before the states", paymentOrder{ProcessedOn: now.Add(-time.Hour)}, epay.ErrPaymentOrderAlreadyPaid, stateCreated},
+		{"paying", paymentOrder{State: statePaying, PayingSince: now.Add(-time.Minute)}, epay.ErrPaymentInProgress, statePaying},
+		{"crashed while paying", paymentOrder{State: statePaying, PayingSince: now.Add(-payingLease)}, nil, statePaying},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			po := c.po
+			err := po.startPayment(now)
+			if !errors.Is(err, c.wantErr) {
+				t.Errorf("expected: %v, got: %v", c.wantErr, err)
+			}
+			if po.State != c.wantState {
+				t.Errorf("expected: state %q, got: %q", c.wantState, po.State)
+			}
+			if c.wantErr == nil && !po.PayingSince.Equal(now) {
+				t.Errorf("expected: paying since %v, got: %v", now, po.PayingSince)
+			}
+		})
+	}
+}
+
+func TestPayTimeoutIsUnderPayingLease(t *testing.T) {
+	// the payment could be posted after the lookup of the existing payment
+	if payTimeout*2 > payingLease {
+		t.Errorf("expected: pay timeout %v to be at most half of the paying lease %v", payTimeout, payingLease)
+	}
+	// a single attempt of each request should fit in the pay timeout
+	if 2*retry.DefaultOptions.Timeout > payTimeout {
+		t.Errorf("expected: pay timeout %v to fit the attempts of %v", payTimeout, retry.DefaultOptions.Timeout)
+	}
+}
+
+func TestAbortPayment(t *testing.T) {
+	now := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	po := &paymentOrder{}
+	if err := po.startPayment(now); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lease of the payment was taken over by another confirmation
+	taken := *po
+	taken.PayingSince = now.Add(payingLease)
+	taken.abortPayment(now)
+	if taken.State != statePaying {
+		t.Errorf("expected: order to stay in paying state, got: %q", taken.State)
+	}
+
+	po.abortPayment(now)
+	if po.State != stateCreated || !po.PayingSince.IsZero() {
+		t.Errorf("expected: order to be released, got: %+v", po)
+	}
+	if err := po.startPayment(now.Add(time.Second)); err != nil {
+		t.Errorf("expected: payment to be started again, got: %v", err)
+	}
+	if !po.PaidAt.Equal(now) {
+		t.Errorf("expected: paid at the first confirmation %v, got: %v", now, po.PaidAt)
+	}
+}
+
+func TestPayWhenPaymentWasRegistered(t *testing.T) {
+	cases := []struct {
+		name     string
+		payments string
+		posts    int32
+	}{
+		{"not registered", `[{"id": 1, "providerName": "epay", "providerPaymentId": "::other tid::"}]`, 1},
+		{"registered by crashed confirmation", `[{"id": 1, "providerName": "epay", "providerPaymentId": "::tid::"}]`, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var posts int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					atomic.AddInt32(&posts, 1)
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte(`{"id": 2}`))
+					return
+				}
+				w.Write([]byte(c.payments))
+			}))
+			defer ts.Close()
+			baseURL, _ := url.Parse(ts.URL)
+
+			uc := NewClient(baseURL, "testing-key", nil, PaymentProvider{Name: "epay"}).(*client)
+			if err := uc.pay(context.Background(), &paymentOrder{ClientID: "708", SubscriberID: "::idn::", TransactionID: "::tid::", Amount: "10.00"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := atomic.LoadInt32(&posts); got != c.posts {
+				t.Errorf("expected: %d posted payments, got: %d", c.posts, got)
+			}
+		})
+	}
+}
+
+func TestPaymentExistsInOlderPayments(t *testing.T) {
+	createdAt := time.Date(2020, 4, 1, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		pageDate time.Time
+		want     bool
+		requests int32
+	}{
+		{"payment on the second page", createdAt, true, 2},
+		{"payments before the order", createdAt.Add(-2 * time.Hour), false, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var requests int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				if r.URL.Query().Get("offset") != "0" {
+					w.Write([]byte(`[{"id": 1, "providerName": "epay", "providerPaymentId": "::tid::"}]`))
+					return
+				}
+				payments := make([]payment, paymentsPageSize)
+				for i := range payments {
+					payments[i] = payment{ID: i + 2, ProviderName: "epay", ProviderPaymentID: fmt.Sprintf("tid-%d", i), CreatedDate: ucrmTime{c.pageDate}}
+				}
+				json.NewEncoder(w).Encode(payments)
+			}))
+			defer ts.Close()
+			baseURL, _ := url.Parse(ts.URL)
+
+			uc := NewClient(baseURL, "testing-key", nil, PaymentProvider{Name: "epay"}).(*client)
+			po := &paymentOrder{ClientID: "708", TransactionID: "::tid::", CreatedAt: createdAt}
+			got, err := uc.paymentExists(context.Background(), po, "::tid::")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Errorf("expected: %v, got: %v", c.want, got)
+			}
+			if requests != c.requests {
+				t.Errorf("expected: %d requests, got: %d", c.requests, requests)
+			}
+		})
+	}
+}
+
+func TestReuseExistingOrder(t *testing.T) {
+	now := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name      string
+		po        paymentOrder
+		requote   bool
+		wantReuse bool
+		wantErr   error
+	}{
+		{"unpaid", paymentOrder{SubscriberID: "::idn::"}, false, true, nil},
+		{"unpaid with requote", paymentOrder{SubscriberID: "::idn::"}, true, false, nil},
+		{"paid", paymentOrder{SubscriberID: "::idn::", State: statePaid, ProcessedOn: now}, false, false, epay.ErrPaymentOrderAlreadyExists},
+		{"paid before the states", paymentOrder{SubscriberID: "::idn::", ProcessedOn: now}, true, false, epay.ErrPaymentOrderAlreadyExists},
+		{"paying", paymentOrder{SubscriberID: "::idn::", State: statePaying, PayingSince: now}, true, false, epay.ErrPaymentOrderAlreadyExists},
+		{"another subscriber", paymentOrder{SubscriberID: "::another idn::"}, false, false, epay.ErrPaymentOrderAlreadyExists},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reuse, err := c.po.reuse("::idn::", c.requote)
+			if !errors.Is(err, c.wantErr) {
+				t.Errorf("expected: %v, got: %v", c.wantErr, err)
+			}
+			if reuse != c.wantReuse {
+				t.Errorf("expected: reuse %v, got: %v", c.wantReuse, reuse)
+			}
+		})
+	}
+}
+
+func TestCheckExpiry(t *testing.T) {
+	created := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	expiry := 48 * time.Hour
+	order := paymentOrder{CreatedAt: created, Amount: "20.00", InvoiceIDs: []string{"101"}, InvoiceAmounts: []string{"20.00"}}
+	current := &paymentOrder{Amount: "20.00", InvoiceIDs: []string{"102"}, InvoiceAmounts: []string{"20.00"}, Items: []string{"service 06/2020"}}
+
+	cases := []struct {
+		name        string
+		po          paymentOrder
+		now         time.Time
+		expiry      time.Duration
+		current     *paymentOrder
+		wantErr     error
+		wantInvoice string
+	}{
+		{"not expired", order, created.Add(time.Hour), expiry, nil, nil, "101"},
+		{"without expiry", order, created.Add(100 * 24 * time.Hour), 0, nil, nil, "101"},
+		{"expired", order, created.Add(expiry), expiry, nil, epay.ErrPaymentOrderExpired, "101"},
+		{"revalidated", order, created.Add(expiry), expiry, current, nil, "102"},
+		{"duties changed", order, created.Add(expiry), expiry, &paymentOrder{Amount: "35.00"}, epay.ErrPaymentOrderExpired, "101"},
+		{"crashed while paying", paymentOrder{CreatedAt: created, State: statePaying, InvoiceIDs: []string{"101"}}, created.Add(expiry), expiry, nil, nil, "101"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			po := c.po
+			err := po.checkExpiry(c.now, c.expiry, c.current)
+			if !errors.Is(err, c.wantErr) {
+				t.Errorf("expected: %v, got: %v", c.wantErr, err)
+			}
+			if po.InvoiceIDs[0] != c.wantInvoice {
+				t.Errorf("expected: allocation to invoice %s, got: %v", c.wantInvoice, po.InvoiceIDs)
+			}
+		})
+	}
+}
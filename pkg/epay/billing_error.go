@@ -0,0 +1,111 @@
+package epay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// excerptLen is the maximum length of the response excerpt kept in BillingError.
+const excerptLen = 256
+
+// BillingError is an error which occurred during a call of the billing backend. The
+// known failures are wrapping the sentinel errors, so they could be checked with errors.Is.
+type BillingError struct {
+	// Backend is the name of the billing backend, e.g telcong or ucrm.
+	Backend string
+
+	// Op is the name of the client operation, e.g GetSubscriberDuties.
+	Op string
+
+	// StatusCode is the HTTP status of the response or 0 when no response was received.
+	StatusCode int
+
+	// Excerpt is the beginning of the response body.
+	Excerpt string
+
+	// Retryable indicates whether the failure is temporary and the request could be retried.
+	Retryable bool
+
+	// Err is the cause of the error.
+	Err error
+}
+
+// NewBillingError creates a new BillingError for the response of the backend. The excerpt
+// is read from the body of the response when it's provided. The error is retryable when
+// no response was received or the backend responded with a temporary failure.
+func NewBillingError(backend, op string, resp *http.Response, err error) *BillingError {
+	e := &BillingError{Backend: backend, Op: op, Err: err}
+	if resp == nil {
+		e.Retryable = err != nil && !errors.Is(err, context.Canceled)
+		return e
+	}
+
+	e.StatusCode = resp.StatusCode
+	e.Retryable = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	if resp.Body != nil {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, excerptLen))
+		e.Excerpt = strings.TrimSpace(string(b))
+	}
+	if e.Err == nil {
+		e.Err = ErrUnknown
+	}
+	return e
+}
+
+func (e *BillingError) Error() string {
+	msg := fmt.Sprintf("%s %s", e.Backend, e.Op)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(": status %d", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Excerpt != "" {
+		msg += fmt.Sprintf(" (response: %s)", e.Excerpt)
+	}
+	return msg
+}
+
+// Unwrap gets the cause of the error.
+func (e *BillingError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable checks whether the provided error is a temporary failure of the billing backend.
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrBackendUnavailable) {
+		return true
+	}
+	var be *BillingError
+	return errors.As(err, &be) && be.Retryable
+}
+
+// ErrorStatus gets the status which is returned to ePay when the provided operation
+// fails with the provided error. It's used by both the HTTP and the TCP integrations.
+func ErrorStatus(op Operation, err error) Status {
+	switch {
+	case err == nil:
+		return StatusSuccess
+	case errors.Is(err, ErrSubscriberNotFound):
+		return StatusSubscriberNotFound
+	case errors.Is(err, ErrPaymentOrderAlreadyExists):
+		return StatusNoDuties
+	case errors.Is(err, ErrPaymentOrderAlreadyPaid):
+		return StatusAlreadyPaid
+	case errors.Is(err, ErrSubscriberAmbiguous):
+		// the subscriber could not be resolved without guessing, so it's not reported as temporary
+		return StatusCommonError
+	case IsRetryable(err), errors.Is(err, ErrPaymentInProgress):
+		return StatusTemporaryNotAvailable
+	case op == OperationCheck:
+		// the check is repeated by ePay, so unknown failures are reported as temporary
+		return StatusTemporaryNotAvailable
+	default:
+		return StatusCommonError
+	}
+}
@@ -0,0 +1,84 @@
+package epay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	notFound := &BillingError{Backend: "telcong", Op: "GetSubscriberDuties", StatusCode: 404, Err: ErrSubscriberNotFound}
+	serverError := &BillingError{Backend: "telcong", Op: "PayPaymentOrder", StatusCode: 500, Retryable: true, Err: ErrUnknown}
+	rejected := &BillingError{Backend: "ucrm", Op: "PayPaymentOrder", StatusCode: 422, Err: ErrUnknown}
+
+	cases := []struct {
+		name string
+		op   Operation
+		err  error
+		want Status
+	}{
+		{"success", OperationCheck, nil, StatusSuccess},
+		{"subscriber not found", OperationCheck, notFound, StatusSubscriberNotFound},
+		{"wrapped subscriber not found", OperationInit, fmt.Errorf("failed due: %w", notFound), StatusSubscriberNotFound},
+		{"order already exists", OperationInit, ErrPaymentOrderAlreadyExists, StatusNoDuties},
+		{"order already paid", OperationConfirm, &BillingError{StatusCode: 409, Err: ErrPaymentOrderAlreadyPaid}, StatusAlreadyPaid},
+		{"payment in progress", OperationConfirm, ErrPaymentInProgress, StatusTemporaryNotAvailable},
+		{"ambiguous subscriber", OperationCheck, &BillingError{Backend: "ucrm", Op: "GetClients", Err: ErrSubscriberAmbiguous}, StatusCommonError},
+		{"backend unavailable", OperationConfirm, ErrBackendUnavailable, StatusTemporaryNotAvailable},
+		{"retryable failure", OperationInit, serverError, StatusTemporaryNotAvailable},
+		{"rejected check", OperationCheck, rejected, StatusTemporaryNotAvailable},
+		{"rejected payment", OperationConfirm, rejected, StatusCommonError},
+		{"unknown error", OperationInit, errors.New("boom"), StatusCommonError},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ErrorStatus(c.op, c.err); got != c.want {
+				t.Errorf("ErrorStatus(%s, %v) = %s, want: %s", c.op, c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestNewBillingError(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusBadGateway, Body: ioutil.NopCloser(strings.NewReader(strings.Repeat("a", 300)))}
+
+	err := NewBillingError("telcong", "GetSubscriberDuties", resp, nil)
+
+	if !errors.Is(err, ErrUnknown) {
+		t.Errorf("expected: %v, got: %v", ErrUnknown, err)
+	}
+	if !err.Retryable {
+		t.Errorf("expected: retryable error, got: %+v", err)
+	}
+	if len(err.Excerpt) != excerptLen {
+		t.Errorf("expected: excerpt of %d bytes, got: %d", excerptLen, len(err.Excerpt))
+	}
+}
+
+func TestNewBillingErrorWithoutResponse(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"network failure", errors.New("connection refused"), true},
+		{"canceled", fmt.Errorf("request failed: %w", context.Canceled), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := NewBillingError("ucrm", "GetClients", nil, c.err)
+			if err.Retryable != c.retryable {
+				t.Errorf("expected: retryable %v, got: %v", c.retryable, err.Retryable)
+			}
+			if !errors.Is(err, c.err) {
+				t.Errorf("expected: %v to be wrapped, got: %v", c.err, err)
+			}
+		})
+	}
+}
@@ -4,8 +4,9 @@ import "context"
 
 // ClientFactory creates a client for particular environment.
 type ClientFactory interface {
-	// Create creates a new client for the provided environment.
-	Create(ctx context.Context, env Environment, idn string) Client
+	// Create creates a new client for the provided environment. An error is returned
+	// when the configuration of the billing backend is not valid.
+	Create(ctx context.Context, env Environment, idn string) (Client, error)
 }
 
 // Client is representing a client to billing.
@@ -0,0 +1,504 @@
+package epay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// the time zones of the payments are available on systems without zoneinfo
+	_ "time/tzdata"
+)
+
+// Metadata attributes of the UCRM backend.
+const (
+	MetadataBillingURL          = "billingUrl"
+	MetadataAPIKey              = "apiKey"
+	MetadataMethodID            = "methodId"
+	MetadataProviderName        = "providerName"
+	MetadataProviderPaymentID   = "providerPaymentId"
+	MetadataProviderPaymentTime = "providerPaymentTime"
+	MetadataOrganizationID      = "organizationId"
+	MetadataInvoiceStatuses     = "invoiceStatuses"
+	MetadataInvoiceTypes        = "invoiceTypes"
+	MetadataInvoicePageSize     = "invoicePageSize"
+	MetadataInvoiceConcurrency  = "invoiceConcurrency"
+	MetadataPaymentAllocation   = "paymentAllocation"
+	MetadataRequoteOrders       = "requoteOrders"
+	MetadataOrderExpiry         = "orderExpiry"
+	MetadataExpiredOrders       = "expiredOrders"
+	MetadataCreditNetting       = "creditNetting"
+	MetadataSubscriberLookup    = "subscriberLookup"
+	MetadataAmbiguousSubscriber = "ambiguousSubscribers"
+	MetadataSubscriberCacheTTL  = "subscriberCacheTTL"
+)
+
+// Statuses of the UCRM invoices.
+const (
+	UCRMInvoiceStatusDraft             = 0
+	UCRMInvoiceStatusUnpaid            = 1
+	UCRMInvoiceStatusPartiallyPaid     = 2
+	UCRMInvoiceStatusPaid              = 3
+	UCRMInvoiceStatusVoid              = 4
+	UCRMInvoiceStatusProcessedProforma = 5
+)
+
+// payableInvoiceStatuses are the statuses of the UCRM invoices which could be included in the
+// duties, where paid and void invoices are never included.
+var payableInvoiceStatuses = map[int]bool{
+	UCRMInvoiceStatusDraft:             true,
+	UCRMInvoiceStatusUnpaid:            true,
+	UCRMInvoiceStatusPartiallyPaid:     true,
+	UCRMInvoiceStatusProcessedProforma: true,
+}
+
+// Types of the UCRM invoices.
+const (
+	UCRMInvoiceTypeInvoice  = "invoice"
+	UCRMInvoiceTypeProforma = "proforma"
+)
+
+// Allocations of the UCRM payments.
+const (
+	// UCRMAllocationInvoices allocates the payment to the invoices which were quoted to the subscriber.
+	UCRMAllocationInvoices = "invoices"
+	// UCRMAllocationAuto lets UCRM apply the payment to the invoices of the client.
+	UCRMAllocationAuto = "auto"
+)
+
+// Handling of the expired UCRM payment orders during the confirmation.
+const (
+	// UCRMExpiredOrdersReject rejects the payment of expired orders.
+	UCRMExpiredOrdersReject = "reject"
+	// UCRMExpiredOrdersRevalidate pays expired orders of which amount is equal to the current duties.
+	UCRMExpiredOrdersRevalidate = "revalidate"
+)
+
+// Policies of netting the credit of the UCRM clients against their invoices.
+const (
+	// UCRMCreditNone is not netting the credit of the client.
+	UCRMCreditNone = "none"
+	// UCRMCreditAccount subtracts the account credit of the client from the invoices.
+	UCRMCreditAccount = "credit"
+	// UCRMCreditBalance limits the duties to the outstanding account balance of the client.
+	UCRMCreditBalance = "balance"
+)
+
+// Strategies of looking up the UCRM clients of the subscribers.
+const (
+	// UCRMLookupUserIdent finds the client by it's custom id.
+	UCRMLookupUserIdent = "userIdent"
+	// UCRMLookupClientID finds the client by it's id.
+	UCRMLookupClientID = "clientId"
+	// UCRMLookupServiceID finds the client by the id of one of it's services.
+	UCRMLookupServiceID = "serviceId"
+	// UCRMLookupCustomAttribute finds the client by the value of a custom attribute,
+	// of which key follows the strategy, e.g customAttribute:contractNumber.
+	UCRMLookupCustomAttribute = "customAttribute"
+)
+
+// Handling of the subscribers which are matching several UCRM clients.
+const (
+	// UCRMAmbiguousNotFound reports the subscriber as not found.
+	UCRMAmbiguousNotFound = "notFound"
+	// UCRMAmbiguousError reports the lookup as failed.
+	UCRMAmbiguousError = "error"
+)
+
+// defaultSubscriberCacheTTL is the time for which the found UCRM clients are cached by default.
+const defaultSubscriberCacheTTL = 5 * time.Minute
+
+// Limits of the invoice pagination of UCRM.
+const (
+	maxInvoicePageSize    = 1000
+	maxInvoiceConcurrency = 10
+)
+
+// metadataAttributes are all metadata attributes which are known by the application.
+var metadataAttributes = map[string]bool{
+	MetadataBillingURL:          true,
+	MetadataAPIKey:              true,
+	MetadataMethodID:            true,
+	MetadataProviderName:        true,
+	MetadataProviderPaymentID:   true,
+	MetadataProviderPaymentTime: true,
+	MetadataOrganizationID:      true,
+	MetadataInvoiceStatuses:     true,
+	MetadataInvoiceTypes:        true,
+	MetadataInvoicePageSize:     true,
+	MetadataInvoiceConcurrency:  true,
+	MetadataPaymentAllocation:   true,
+	MetadataRequoteOrders:       true,
+	MetadataOrderExpiry:         true,
+	MetadataExpiredOrders:       true,
+	MetadataCreditNetting:       true,
+	MetadataSubscriberLookup:    true,
+	MetadataAmbiguousSubscriber: true,
+	MetadataSubscriberCacheTTL:  true,
+}
+
+// ConfigError is the error returned when the configuration of the environment is not valid.
+type ConfigError struct {
+	// Field is the name of the property or the metadata attribute which is not valid.
+	Field string
+
+	// Reason describes why the value is not valid.
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// TelcoNGConfig is the configuration of the TelcoNG billing backend.
+type TelcoNGConfig struct {
+	// BillingURL is the URL of the billing API.
+	BillingURL *url.URL
+
+	// JWTKey is the JSON key of the service account which is used for authentication.
+	JWTKey []byte
+}
+
+// UCRMConfig is the configuration of the UCRM billing backend which is
+// provided through the metadata attributes of the environment.
+type UCRMConfig struct {
+	// BillingURL is the URL of the UCRM API.
+	BillingURL *url.URL
+
+	// APIKey is the application key used for authentication.
+	APIKey string
+
+	// MethodID is the id of the payment method which is used for the payments.
+	MethodID string
+
+	// ProviderName is the name of the payment provider, which is the source of the payments.
+	ProviderName string
+
+	// ProviderPaymentID is the template of the id of the payments in the payment provider, in
+	// which {tid} and {idn} are replaced with the transaction and the subscriber. The transaction
+	// is used when it's empty.
+	ProviderPaymentID string
+
+	// ProviderPaymentTime is the time zone in which the time of the payments in the payment
+	// provider is posted, where UTC is used when it's empty.
+	ProviderPaymentTime string
+
+	// OrganizationID is the comma separated list of ids of the organizations of which
+	// invoices are paid. Clients of all organizations are found when it's empty.
+	OrganizationID string
+
+	// InvoiceStatuses are the statuses of the invoices which are included in the duties.
+	InvoiceStatuses []int
+
+	// InvoiceTypes are the types of the invoices which are included in the duties.
+	// Invoices of all types are included when it's empty.
+	InvoiceTypes []string
+
+	// InvoicePageSize is the number of invoices which are fetched with a single request.
+	InvoicePageSize int
+
+	// InvoiceConcurrency is the number of invoice pages which are fetched concurrently.
+	InvoiceConcurrency int
+
+	// PaymentAllocation is the allocation of the payments to the invoices.
+	PaymentAllocation string
+
+	// RequoteOrders indicates whether unpaid orders are quoted again when they are created again.
+	RequoteOrders bool
+
+	// OrderExpiry is the time after which the unpaid orders are expired. Orders are not
+	// expiring when it's zero.
+	OrderExpiry time.Duration
+
+	// ExpiredOrders is the handling of the expired orders during the confirmation.
+	ExpiredOrders string
+
+	// CreditNetting is the policy of netting the credit of the client against the invoices.
+	CreditNetting string
+
+	// SubscriberLookup are the strategies which are tried in order to find the client of the subscriber.
+	SubscriberLookup []string
+
+	// AmbiguousSubscribers is the handling of the subscribers which are matching several clients.
+	AmbiguousSubscribers string
+
+	// SubscriberCacheTTL is the time for which the found clients are cached. Clients are
+	// not cached when it's zero.
+	SubscriberCacheTTL time.Duration
+}
+
+// TelcoNGConfig gets the configuration of the TelcoNG billing backend.
+func (e *Environment) TelcoNGConfig() (*TelcoNGConfig, error) {
+	billingURL, err := parseURL("billingURL", e.BillingURL)
+	if err != nil {
+		return nil, err
+	}
+
+	key := e.BillingJWTKey
+	if key == "" {
+		key = e.BillingKey
+	}
+	if key == "" {
+		return nil, &ConfigError{Field: "billingKey", Reason: "is required"}
+	}
+
+	var sa struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal([]byte(key), &sa); err != nil {
+		return nil, &ConfigError{Field: "billingKey", Reason: fmt.Sprintf("is not a JSON key: %v", err)}
+	}
+	if sa.Type != "service_account" {
+		return nil, &ConfigError{Field: "billingKey", Reason: fmt.Sprintf("has type '%s' instead of 'service_account'", sa.Type)}
+	}
+	if sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return nil, &ConfigError{Field: "billingKey", Reason: "is missing client_email or private_key"}
+	}
+
+	return &TelcoNGConfig{BillingURL: billingURL, JWTKey: []byte(key)}, nil
+}
+
+// UCRMConfig gets the configuration of the UCRM billing backend. Nil is returned
+// when the UCRM backend is not configured for the environment.
+func (e *Environment) UCRMConfig() (*UCRMConfig, error) {
+	rawURL, ok := e.Metadata[MetadataBillingURL]
+	if !ok {
+		return nil, nil
+	}
+
+	billingURL, err := parseURL("metadata."+MetadataBillingURL, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &UCRMConfig{
+		BillingURL:          billingURL,
+		APIKey:              e.Metadata[MetadataAPIKey],
+		MethodID:            e.Metadata[MetadataMethodID],
+		ProviderName:        e.Metadata[MetadataProviderName],
+		ProviderPaymentID:   e.Metadata[MetadataProviderPaymentID],
+		ProviderPaymentTime: e.Metadata[MetadataProviderPaymentTime],
+		OrganizationID:      e.Metadata[MetadataOrganizationID],
+	}
+	if c.APIKey == "" {
+		return nil, &ConfigError{Field: "metadata." + MetadataAPIKey, Reason: "is required"}
+	}
+	if c.MethodID == "" {
+		return nil, &ConfigError{Field: "metadata." + MetadataMethodID, Reason: "is required"}
+	}
+	// the payment ID identifies the payments of the transactions in UCRM
+	if c.ProviderPaymentID != "" && !strings.Contains(c.ProviderPaymentID, "{tid}") {
+		return nil, &ConfigError{Field: "metadata." + MetadataProviderPaymentID, Reason: fmt.Sprintf("'%s' is missing the {tid} placeholder", c.ProviderPaymentID)}
+	}
+	if _, err := time.LoadLocation(c.ProviderPaymentTime); err != nil {
+		return nil, &ConfigError{Field: "metadata." + MetadataProviderPaymentTime, Reason: fmt.Sprintf("'%s' is not a time zone, e.g Europe/Sofia", c.ProviderPaymentTime)}
+	}
+
+	c.InvoiceStatuses = []int{UCRMInvoiceStatusUnpaid, UCRMInvoiceStatusPartiallyPaid}
+	if v, ok := e.Metadata[MetadataInvoiceStatuses]; ok {
+		c.InvoiceStatuses = nil
+		for _, s := range SplitList(v) {
+			status, err := strconv.Atoi(s)
+			if err != nil || !payableInvoiceStatuses[status] {
+				return nil, &ConfigError{Field: "metadata." + MetadataInvoiceStatuses, Reason: fmt.Sprintf("'%s' is not one of 0 (draft), 1 (unpaid), 2 (partially paid) or 5 (proforma)", s)}
+			}
+			c.InvoiceStatuses = append(c.InvoiceStatuses, status)
+		}
+		if len(c.InvoiceStatuses) == 0 {
+			return nil, &ConfigError{Field: "metadata." + MetadataInvoiceStatuses, Reason: "at least one status is required"}
+		}
+	}
+
+	for _, t := range SplitList(e.Metadata[MetadataInvoiceTypes]) {
+		if t != UCRMInvoiceTypeInvoice && t != UCRMInvoiceTypeProforma {
+			return nil, &ConfigError{Field: "metadata." + MetadataInvoiceTypes, Reason: fmt.Sprintf("'%s' is not one of invoice or proforma", t)}
+		}
+		c.InvoiceTypes = append(c.InvoiceTypes, t)
+	}
+
+	if c.InvoicePageSize, err = parseLimit(e.Metadata, MetadataInvoicePageSize, 100, maxInvoicePageSize); err != nil {
+		return nil, err
+	}
+	if c.InvoiceConcurrency, err = parseLimit(e.Metadata, MetadataInvoiceConcurrency, 1, maxInvoiceConcurrency); err != nil {
+		return nil, err
+	}
+
+	c.PaymentAllocation = UCRMAllocationInvoices
+	if v, ok := e.Metadata[MetadataPaymentAllocation]; ok {
+		if v != UCRMAllocationInvoices && v != UCRMAllocationAuto {
+			return nil, &ConfigError{Field: "metadata." + MetadataPaymentAllocation, Reason: fmt.Sprintf("'%s' is not one of invoices or auto", v)}
+		}
+		c.PaymentAllocation = v
+	}
+
+	if v, ok := e.Metadata[MetadataRequoteOrders]; ok {
+		requote, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &ConfigError{Field: "metadata." + MetadataRequoteOrders, Reason: fmt.Sprintf("'%s' is not a boolean", v)}
+		}
+		c.RequoteOrders = requote
+	}
+
+	if v, ok := e.Metadata[MetadataOrderExpiry]; ok {
+		expiry, err := time.ParseDuration(v)
+		if err != nil || expiry <= 0 {
+			return nil, &ConfigError{Field: "metadata." + MetadataOrderExpiry, Reason: fmt.Sprintf("'%s' is not a positive duration, e.g 48h", v)}
+		}
+		c.OrderExpiry = expiry
+	}
+
+	c.ExpiredOrders = UCRMExpiredOrdersReject
+	if v, ok := e.Metadata[MetadataExpiredOrders]; ok {
+		if v != UCRMExpiredOrdersReject && v != UCRMExpiredOrdersRevalidate {
+			return nil, &ConfigError{Field: "metadata." + MetadataExpiredOrders, Reason: fmt.Sprintf("'%s' is not one of reject or revalidate", v)}
+		}
+		c.ExpiredOrders = v
+	}
+
+	c.CreditNetting = UCRMCreditNone
+	if v, ok := e.Metadata[MetadataCreditNetting]; ok {
+		if v != UCRMCreditNone && v != UCRMCreditAccount && v != UCRMCreditBalance {
+			return nil, &ConfigError{Field: "metadata." + MetadataCreditNetting, Reason: fmt.Sprintf("'%s' is not one of none, credit or balance", v)}
+		}
+		c.CreditNetting = v
+	}
+
+	c.SubscriberLookup = []string{UCRMLookupUserIdent}
+	if v, ok := e.Metadata[MetadataSubscriberLookup]; ok {
+		c.SubscriberLookup = nil
+		for _, s := range SplitList(v) {
+			if err := validateLookup(s); err != nil {
+				return nil, &ConfigError{Field: "metadata." + MetadataSubscriberLookup, Reason: err.Error()}
+			}
+			c.SubscriberLookup = append(c.SubscriberLookup, s)
+		}
+		if len(c.SubscriberLookup) == 0 {
+			return nil, &ConfigError{Field: "metadata." + MetadataSubscriberLookup, Reason: "at least one strategy is required"}
+		}
+	}
+
+	c.AmbiguousSubscribers = UCRMAmbiguousNotFound
+	if v, ok := e.Metadata[MetadataAmbiguousSubscriber]; ok {
+		if v != UCRMAmbiguousNotFound && v != UCRMAmbiguousError {
+			return nil, &ConfigError{Field: "metadata." + MetadataAmbiguousSubscriber, Reason: fmt.Sprintf("'%s' is not one of notFound or error", v)}
+		}
+		c.AmbiguousSubscribers = v
+	}
+
+	c.SubscriberCacheTTL = defaultSubscriberCacheTTL
+	if v, ok := e.Metadata[MetadataSubscriberCacheTTL]; ok {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl < 0 {
+			return nil, &ConfigError{Field: "metadata." + MetadataSubscriberCacheTTL, Reason: fmt.Sprintf("'%s' is not a duration, e.g 5m or 0s", v)}
+		}
+		c.SubscriberCacheTTL = ttl
+	}
+	return c, nil
+}
+
+// validateLookup validates the strategy of looking up the UCRM clients.
+func validateLookup(strategy string) error {
+	switch strategy {
+	case UCRMLookupUserIdent, UCRMLookupClientID, UCRMLookupServiceID:
+		return nil
+	}
+	if key := strings.TrimPrefix(strategy, UCRMLookupCustomAttribute+":"); key != strategy {
+		if key == "" {
+			return fmt.Errorf("'%s' is missing the key of the attribute", strategy)
+		}
+		return nil
+	}
+	return fmt.Errorf("'%s' is not one of userIdent, clientId, serviceId or customAttribute:<key>", strategy)
+}
+
+// SplitList splits the comma separated list of values, where the empty values are skipped.
+func SplitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseLimit parses the metadata attribute as a number between 1 and max. The provided
+// default value is returned when the attribute is not set.
+func parseLimit(metadata map[string]string, attr string, def, max int) (int, error) {
+	v, ok := metadata[attr]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > max {
+		return 0, &ConfigError{Field: "metadata." + attr, Reason: fmt.Sprintf("'%s' is not a number between 1 and %d", v, max)}
+	}
+	return n, nil
+}
+
+// Validate validates the configuration of the environment and returns a ConfigError
+// describing the first property which is not valid.
+func (e *Environment) Validate() error {
+	if e.EpaySecret == "" && len(e.EpaySecrets) == 0 {
+		return &ConfigError{Field: "epaySecret", Reason: "is required"}
+	}
+
+	switch e.NameMasking {
+	case NameMaskingNone, NameMaskingFull, NameMaskingInitials, NameMaskingFirstName, "":
+	default:
+		return &ConfigError{Field: "nameMasking", Reason: fmt.Sprintf("'%s' is not one of none, full, initials or firstname", e.NameMasking)}
+	}
+
+	for i, r := range e.IDNRules {
+		if err := r.Validate(); err != nil {
+			return &ConfigError{Field: fmt.Sprintf("idnRules[%d]", i), Reason: err.Error()}
+		}
+	}
+
+	if cb := e.CircuitBreaker; cb != nil && (cb.FailureThreshold < 0 || cb.OpenSeconds < 0 || cb.HalfOpenRequests < 0) {
+		return &ConfigError{Field: "circuitBreaker", Reason: "values should not be negative"}
+	}
+
+	var unknown []string
+	for k := range e.Metadata {
+		if !metadataAttributes[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &ConfigError{Field: "metadata", Reason: fmt.Sprintf("unknown attributes: %s", strings.Join(unknown, ", "))}
+	}
+
+	ucrm, err := e.UCRMConfig()
+	if err != nil {
+		return err
+	}
+
+	// TelcoNG is the default backend, so it should be valid when it's configured or UCRM is not.
+	if ucrm == nil || e.BillingURL != "" || e.BillingJWTKey != "" || e.BillingKey != "" {
+		if _, err := e.TelcoNGConfig(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseURL(field, value string) (*url.URL, error) {
+	if value == "" {
+		return nil, &ConfigError{Field: field, Reason: "is required"}
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, &ConfigError{Field: field, Reason: fmt.Sprintf("is not a valid URL: %v", err)}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, &ConfigError{Field: field, Reason: fmt.Sprintf("'%s' is not an absolute http(s) URL", value)}
+	}
+	return u, nil
+}
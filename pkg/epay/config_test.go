@@ -0,0 +1,235 @@
+package epay
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testJWTKey = `{"type":"service_account","client_email":"billing@telcong.com","private_key":"key"}`
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		env  Environment
+		want *ConfigError
+	}{
+		{
+			name: "telcong",
+			env:  Environment{EpaySecret: "secret", BillingURL: "https://cloud.telcong.com", BillingJWTKey: testJWTKey},
+		},
+		{
+			name: "ucrm",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1",
+			}},
+		},
+		{
+			name: "no secret",
+			env:  Environment{BillingURL: "https://cloud.telcong.com", BillingJWTKey: testJWTKey},
+			want: &ConfigError{Field: "epaySecret", Reason: "is required"},
+		},
+		{
+			name: "relative billing url",
+			env:  Environment{EpaySecret: "secret", BillingURL: "cloud.telcong.com", BillingJWTKey: testJWTKey},
+			want: &ConfigError{Field: "billingURL", Reason: "'cloud.telcong.com' is not an absolute http(s) URL"},
+		},
+		{
+			name: "billing key is not json",
+			env:  Environment{EpaySecret: "secret", BillingURL: "https://cloud.telcong.com", BillingJWTKey: "key"},
+			want: &ConfigError{Field: "billingKey", Reason: "is not a JSON key: invalid character 'k' looking for beginning of value"},
+		},
+		{
+			name: "typo in metadata",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingURL": "https://ucrm.example.com", "apiKey": "key", "methodId": "1",
+			}},
+			want: &ConfigError{Field: "metadata", Reason: "unknown attributes: billingURL"},
+		},
+		{
+			name: "ucrm without api key",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "methodId": "1",
+			}},
+			want: &ConfigError{Field: "metadata.apiKey", Reason: "is required"},
+		},
+		{
+			name: "ucrm with invoice selection",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1",
+				"invoiceStatuses": "0, 1,2", "invoiceTypes": "proforma", "invoicePageSize": "500", "invoiceConcurrency": "4",
+			}},
+		},
+		{
+			name: "unknown invoice status",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "invoiceStatuses": "1,paid",
+			}},
+			want: &ConfigError{Field: "metadata.invoiceStatuses", Reason: "'paid' is not one of 0 (draft), 1 (unpaid), 2 (partially paid) or 5 (proforma)"},
+		},
+		{
+			name: "paid invoice status",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "invoiceStatuses": "1,3",
+			}},
+			want: &ConfigError{Field: "metadata.invoiceStatuses", Reason: "'3' is not one of 0 (draft), 1 (unpaid), 2 (partially paid) or 5 (proforma)"},
+		},
+		{
+			name: "void invoice status",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "invoiceStatuses": "4",
+			}},
+			want: &ConfigError{Field: "metadata.invoiceStatuses", Reason: "'4' is not one of 0 (draft), 1 (unpaid), 2 (partially paid) or 5 (proforma)"},
+		},
+		{
+			name: "unknown invoice type",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "invoiceTypes": "draft",
+			}},
+			want: &ConfigError{Field: "metadata.invoiceTypes", Reason: "'draft' is not one of invoice or proforma"},
+		},
+		{
+			name: "too large invoice page",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "invoicePageSize": "5000",
+			}},
+			want: &ConfigError{Field: "metadata.invoicePageSize", Reason: "'5000' is not a number between 1 and 1000"},
+		},
+		{
+			name: "unknown payment allocation",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "paymentAllocation": "manual",
+			}},
+			want: &ConfigError{Field: "metadata.paymentAllocation", Reason: "'manual' is not one of invoices or auto"},
+		},
+		{
+			name: "requote orders is not boolean",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "requoteOrders": "sometimes",
+			}},
+			want: &ConfigError{Field: "metadata.requoteOrders", Reason: "'sometimes' is not a boolean"},
+		},
+		{
+			name: "order expiry is not a duration",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "orderExpiry": "2 days",
+			}},
+			want: &ConfigError{Field: "metadata.orderExpiry", Reason: "'2 days' is not a positive duration, e.g 48h"},
+		},
+		{
+			name: "unknown credit netting",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "creditNetting": "all",
+			}},
+			want: &ConfigError{Field: "metadata.creditNetting", Reason: "'all' is not one of none, credit or balance"},
+		},
+		{
+			name: "provider payment attributes",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "providerPaymentId": "epay-{tid}", "providerPaymentTime": "Europe/Sofia",
+			}},
+		},
+		{
+			name: "payment id template without transaction",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "providerPaymentId": "epay-{idn}",
+			}},
+			want: &ConfigError{Field: "metadata.providerPaymentId", Reason: "'epay-{idn}' is missing the {tid} placeholder"},
+		},
+		{
+			name: "unknown payment time zone",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "providerPaymentTime": "Sofia",
+			}},
+			want: &ConfigError{Field: "metadata.providerPaymentTime", Reason: "'Sofia' is not a time zone, e.g Europe/Sofia"},
+		},
+		{
+			name: "unknown subscriber lookup",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "subscriberLookup": "userIdent,email",
+			}},
+			want: &ConfigError{Field: "metadata.subscriberLookup", Reason: "'email' is not one of userIdent, clientId, serviceId or customAttribute:<key>"},
+		},
+		{
+			name: "custom attribute lookup without key",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "subscriberLookup": "customAttribute:",
+			}},
+			want: &ConfigError{Field: "metadata.subscriberLookup", Reason: "'customAttribute:' is missing the key of the attribute"},
+		},
+		{
+			name: "unknown ambiguous subscribers",
+			env: Environment{EpaySecret: "secret", Metadata: map[string]string{
+				"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1", "ambiguousSubscribers": "first",
+			}},
+			want: &ConfigError{Field: "metadata.ambiguousSubscribers", Reason: "'first' is not one of notFound or error"},
+		},
+		{
+			name: "unknown name masking",
+			env:  Environment{EpaySecret: "secret", NameMasking: "partial"},
+			want: &ConfigError{Field: "nameMasking", Reason: "'partial' is not one of none, full, initials or firstname"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.env.Validate()
+			if c.want == nil {
+				if err != nil {
+					t.Errorf("expected environment to be valid, but got: %v", err)
+				}
+				return
+			}
+
+			var got *ConfigError
+			if !errors.As(err, &got) {
+				t.Fatalf("expected: ConfigError, got: %v", err)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("unexpected error (-want +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestUCRMConfigInvoiceSelection(t *testing.T) {
+	cases := []struct {
+		name     string
+		metadata map[string]string
+		want     *UCRMConfig
+	}{
+		{
+			name:     "defaults",
+			metadata: map[string]string{},
+			want:     &UCRMConfig{InvoiceStatuses: []int{1, 2}, InvoicePageSize: 100, InvoiceConcurrency: 1, PaymentAllocation: "invoices", ExpiredOrders: "reject", CreditNetting: "none", SubscriberLookup: []string{"userIdent"}, AmbiguousSubscribers: "notFound", SubscriberCacheTTL: 5 * time.Minute},
+		},
+		{
+			name:     "configured",
+			metadata: map[string]string{"invoiceStatuses": "1,2,5", "invoiceTypes": "invoice,proforma", "invoicePageSize": "50", "invoiceConcurrency": "3", "paymentAllocation": "auto", "requoteOrders": "true", "orderExpiry": "48h", "expiredOrders": "revalidate", "creditNetting": "balance", "subscriberLookup": "clientId, customAttribute:contract", "ambiguousSubscribers": "error", "subscriberCacheTTL": "0s"},
+			want:     &UCRMConfig{InvoiceStatuses: []int{1, 2, 5}, InvoiceTypes: []string{"invoice", "proforma"}, InvoicePageSize: 50, InvoiceConcurrency: 3, PaymentAllocation: "auto", RequoteOrders: true, OrderExpiry: 48 * time.Hour, ExpiredOrders: "revalidate", CreditNetting: "balance", SubscriberLookup: []string{"clientId", "customAttribute:contract"}, AmbiguousSubscribers: "error"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			metadata := map[string]string{"billingUrl": "https://ucrm.example.com", "apiKey": "key", "methodId": "1"}
+			for k, v := range c.metadata {
+				metadata[k] = v
+			}
+			env := Environment{Metadata: metadata}
+
+			got, err := env.UCRMConfig()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			opt := cmp.FilterPath(func(p cmp.Path) bool {
+				return p.Last().String() == ".BillingURL" || p.Last().String() == ".APIKey" || p.Last().String() == ".MethodID"
+			}, cmp.Ignore())
+			if diff := cmp.Diff(c.want, got, opt); diff != "" {
+				t.Errorf("unexpected config (-want +got): %s", diff)
+			}
+		})
+	}
+}
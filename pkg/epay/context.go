@@ -0,0 +1,24 @@
+package epay
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey int
+
+// paymentTimeKey is the key of the time of the payment which is reported by ePay.
+const paymentTimeKey contextKey = iota
+
+// WithPaymentTime returns a copy of the provided context which carries the time of the
+// payment as it was reported by ePay.
+func WithPaymentTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, paymentTimeKey, t)
+}
+
+// PaymentTimeFromContext gets the time of the payment which is associated with the provided
+// context. The returned bool is false when ePay has not reported the time of the payment.
+func PaymentTimeFromContext(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(paymentTimeKey).(time.Time)
+	return t, ok && !t.IsZero()
+}
@@ -5,23 +5,19 @@ import (
 	"io"
 )
 
-// Status is representing the response status which is returned
-// back to the epay processor
-type Status string
-
 const (
 	// BillReturned indicates that bill is returned successfully
-	BillReturned Status = "00"
+	BillReturned = StatusSuccess
 	// NoCurrentBill indicates that subscriber doesn't have current bill
-	NoCurrentBill Status = "62"
+	NoCurrentBill = StatusNoDuties
 	// UnknownSubscriber indicates that subscriber ID was broken
-	UnknownSubscriber Status = "14"
+	UnknownSubscriber = StatusSubscriberNotFound
 	// PaymentProcessed indicates that payment was processed successfully
-	PaymentProcessed Status = "00"
+	PaymentProcessed = StatusSuccess
 	// PaymentAlreadyProcessed indicates that payment was already processed
-	PaymentAlreadyProcessed Status = "94"
+	PaymentAlreadyProcessed = StatusAlreadyPaid
 	// CommonError indicates an error which was occurred during payment
-	CommonError Status = "96"
+	CommonError = StatusCommonError
 )
 
 // request is representing a single EPAY request
@@ -39,12 +35,20 @@ type response interface {
 }
 
 type billResponse struct {
-	Amount int
-	Status Status
+	Amount    int
+	Status    Status
+	ShortDesc string
+	LongDesc  string
 }
 
 func (b *billResponse) Write(w io.Writer) (int, error) {
 	cmd := fmt.Sprintf("XTYPE=RBN\nXVALIDTO=%s\nAMOUNT=%d\nSTATUS=%s\n", "", b.Amount, string(b.Status))
+	if b.ShortDesc != "" {
+		cmd += fmt.Sprintf("SHORTDESC=%s\n", b.ShortDesc)
+	}
+	if b.LongDesc != "" {
+		cmd += fmt.Sprintf("LONGDESC=%s\n", b.LongDesc)
+	}
 	return w.Write([]byte(cmd))
 }
 
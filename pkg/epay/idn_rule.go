@@ -0,0 +1,182 @@
+package epay
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Operation is representing the ePay operation which is requested.
+type Operation string
+
+const (
+	// OperationCheck is the operation for checking of subscriber duties.
+	OperationCheck Operation = "CHECK"
+	// OperationInit is the operation for the creation of payment order.
+	OperationInit Operation = "INIT"
+	// OperationConfirm is the operation for the confirmation of payment order.
+	OperationConfirm Operation = "CONFIRM"
+)
+
+// IDNAction is the action which is taken when IDN rule is matched.
+type IDNAction string
+
+const (
+	// IDNActionIgnore ignores the IDN as if it's not an existing subscriber.
+	IDNActionIgnore IDNAction = "ignore"
+	// IDNActionDeny denies processing of requests for the IDN.
+	IDNActionDeny IDNAction = "deny"
+)
+
+const (
+	defaultIgnoreStatus = StatusSubscriberNotFound
+	defaultDenyStatus   = StatusCommonError
+)
+
+// DefaultIDNRules are the rules of the environments which have no IDN rules. They keep
+// ignoring the checks of the testing IDN of ePay, which were ignored before the IDN rules
+// were configured per environment. Environments could opt out with an empty list of rules.
+var DefaultIDNRules = []IDNRule{
+	{Action: IDNActionIgnore, Exact: []string{"1111111111"}, Operations: []Operation{OperationCheck}},
+}
+
+// IDNRule is a rule for IDNs which should not be processed by the billing backend. The
+// rule matches an IDN when any of it's matchers matches it.
+type IDNRule struct {
+	// Action is the action of the rule. Ignore is used when it's not provided.
+	Action IDNAction `json:"action,omitempty"`
+
+	// Exact is a list of IDNs that are matched exactly.
+	Exact []string `json:"exact,omitempty"`
+
+	// Prefix is a list of prefixes of matching IDNs.
+	Prefix []string `json:"prefix,omitempty"`
+
+	// Regex is a regular expression matching IDNs.
+	Regex string `json:"regex,omitempty"`
+
+	// Range is an inclusive range of numeric IDNs.
+	Range *IDNRange `json:"range,omitempty"`
+
+	// Status is the status which is returned back to ePay. When it's not provided
+	// 14 is returned for ignored and 96 for denied IDNs.
+	Status Status `json:"status,omitempty"`
+
+	// Operations is the list of operations the rule is applied to. The rule is
+	// applied to all operations when no operations are provided.
+	Operations []Operation `json:"operations,omitempty"`
+}
+
+// IDNRange is an inclusive range of numeric IDNs.
+type IDNRange struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// Validate validates the rule and returns an error describing the problem if
+// the rule is not valid.
+func (r IDNRule) Validate() error {
+	if r.Action != "" && r.Action != IDNActionIgnore && r.Action != IDNActionDeny {
+		return fmt.Errorf("unknown action '%s'", r.Action)
+	}
+	if len(r.Exact) == 0 && len(r.Prefix) == 0 && r.Regex == "" && r.Range == nil {
+		return fmt.Errorf("no exact, prefix, regex or range matcher is provided")
+	}
+	if r.Regex != "" {
+		if _, err := compileRegex(r.Regex); err != nil {
+			return fmt.Errorf("bad regex '%s': %v", r.Regex, err)
+		}
+	}
+	if r.Range != nil && r.Range.From > r.Range.To {
+		return fmt.Errorf("range from %d is after %d", r.Range.From, r.Range.To)
+	}
+	for _, op := range r.Operations {
+		if op != OperationCheck && op != OperationInit && op != OperationConfirm {
+			return fmt.Errorf("unknown operation '%s'", op)
+		}
+	}
+	if r.Status != "" && !r.Status.Known() {
+		return fmt.Errorf("unknown status '%s'", r.Status)
+	}
+	// the billing is not called for the matched IDNs, so they could never succeed
+	if r.Status == StatusSuccess {
+		return fmt.Errorf("status '%s' could not be returned without calling the billing", r.Status)
+	}
+	return nil
+}
+
+// Matches checks whether the rule matches the provided IDN for the provided operation.
+func (r IDNRule) Matches(op Operation, idn string) bool {
+	if !r.appliesTo(op) {
+		return false
+	}
+
+	for _, v := range r.Exact {
+		if v == idn {
+			return true
+		}
+	}
+
+	for _, p := range r.Prefix {
+		if strings.HasPrefix(idn, p) {
+			return true
+		}
+	}
+
+	if r.Regex != "" {
+		if re, err := compileRegex(r.Regex); err == nil && re.MatchString(idn) {
+			return true
+		}
+	}
+
+	if r.Range != nil {
+		if n, err := strconv.ParseUint(idn, 10, 64); err == nil && n >= r.Range.From && n <= r.Range.To {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResponseStatus gets the status which should be returned back to ePay when
+// the rule is matched.
+func (r IDNRule) ResponseStatus() Status {
+	if r.Status != "" {
+		return r.Status
+	}
+	if r.Action == IDNActionDeny {
+		return defaultDenyStatus
+	}
+	return defaultIgnoreStatus
+}
+
+func (r IDNRule) appliesTo(op Operation) bool {
+	if len(r.Operations) == 0 {
+		return true
+	}
+	for _, o := range r.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// regexes are the compiled regexes of the rules by their pattern. The rules are validated when
+// they are loaded, so the regexes are compiled once and are not compiled for each request.
+var regexes sync.Map
+
+// compileRegex gets the compiled regex of the provided pattern, which is compiled only once.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexes.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexes.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,94 @@
+package epay
+
+import "testing"
+
+func TestIDNRuleMatches(t *testing.T) {
+	cases := []struct {
+		name string
+		rule IDNRule
+		op   Operation
+		idn  string
+		want bool
+	}{
+		{"exact", IDNRule{Exact: []string{"1111111111"}}, OperationCheck, "1111111111", true},
+		{"exact not matching", IDNRule{Exact: []string{"1111111111"}}, OperationCheck, "111111111", false},
+		{"prefix", IDNRule{Prefix: []string{"99"}}, OperationInit, "991234", true},
+		{"regex", IDNRule{Regex: "^0+$"}, OperationConfirm, "0000", true},
+		{"regex not matching", IDNRule{Regex: "^0+$"}, OperationConfirm, "0001", false},
+		{"range", IDNRule{Range: &IDNRange{From: 100, To: 200}}, OperationCheck, "200", true},
+		{"out of range", IDNRule{Range: &IDNRange{From: 100, To: 200}}, OperationCheck, "201", false},
+		{"range of not numeric idn", IDNRule{Range: &IDNRange{From: 100, To: 200}}, OperationCheck, "1a0", false},
+		{"operation matches", IDNRule{Exact: []string{"1"}, Operations: []Operation{OperationCheck}}, OperationCheck, "1", true},
+		{"operation not matching", IDNRule{Exact: []string{"1"}, Operations: []Operation{OperationCheck}}, OperationConfirm, "1", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.rule.Matches(c.op, c.idn); got != c.want {
+				t.Errorf("Matches(%s, %s) = %v, want: %v", c.op, c.idn, got, c.want)
+			}
+		})
+	}
+}
+
+func TestIDNRuleValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		rule    IDNRule
+		wantErr bool
+	}{
+		{"valid", IDNRule{Action: IDNActionDeny, Prefix: []string{"9"}}, false},
+		{"no matchers", IDNRule{}, true},
+		{"bad regex", IDNRule{Regex: "(["}, true},
+		{"bad range", IDNRule{Range: &IDNRange{From: 2, To: 1}}, true},
+		{"unknown action", IDNRule{Action: "drop", Exact: []string{"1"}}, true},
+		{"unknown operation", IDNRule{Exact: []string{"1"}, Operations: []Operation{"PAY"}}, true},
+		{"known status", IDNRule{Action: IDNActionDeny, Exact: []string{"1"}, Status: StatusTemporaryNotAvailable}, false},
+		{"unknown status", IDNRule{Exact: []string{"1"}, Status: "01"}, true},
+		{"success status", IDNRule{Exact: []string{"1"}, Status: StatusSuccess}, true},
+		{"success status of check", IDNRule{Exact: []string{"1"}, Status: StatusSuccess, Operations: []Operation{OperationCheck}}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.rule.Validate(); (err != nil) != c.wantErr {
+				t.Errorf("Validate() = %v, wantErr: %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestIDNRuleResponseStatus(t *testing.T) {
+	if got := (IDNRule{}).ResponseStatus(); got != "14" {
+		t.Errorf("ignore status = %s, want: 14", got)
+	}
+	if got := (IDNRule{Action: IDNActionDeny}).ResponseStatus(); got != "96" {
+		t.Errorf("deny status = %s, want: 96", got)
+	}
+	if got := (IDNRule{Action: IDNActionDeny, Status: "80"}).ResponseStatus(); got != "80" {
+		t.Errorf("custom status = %s, want: 80", got)
+	}
+}
+
+func TestMatchDefaultIDNRules(t *testing.T) {
+	cases := []struct {
+		name  string
+		rules []IDNRule
+		op    Operation
+		want  bool
+	}{
+		{"no rules", nil, OperationCheck, true},
+		{"no rules of other operation", nil, OperationConfirm, false},
+		{"empty rules", []IDNRule{}, OperationCheck, false},
+		{"configured rules", []IDNRule{{Exact: []string{"2"}}}, OperationCheck, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			env := &Environment{IDNRules: c.rules}
+			if got := env.MatchIDNRule(c.op, "1111111111") != nil; got != c.want {
+				t.Errorf("MatchIDNRule(%s) = %v, want: %v", c.op, got, c.want)
+			}
+		})
+	}
+}
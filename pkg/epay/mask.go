@@ -0,0 +1,61 @@
+package epay
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// NameMasking is the policy used for masking of customer names before they
+// are shown to whoever asks for the duties of an IDN.
+type NameMasking string
+
+const (
+	// NameMaskingNone keeps the customer name as it is.
+	NameMaskingNone NameMasking = "none"
+	// NameMaskingFull hides the customer name completely.
+	NameMaskingFull NameMasking = "full"
+	// NameMaskingInitials keeps only the initials of the customer name, e.g "И. П."
+	NameMaskingInitials NameMasking = "initials"
+	// NameMaskingFirstName keeps the first name and masks the rest of the name, e.g "Иван П*****"
+	NameMaskingFirstName NameMasking = "firstname"
+)
+
+const maskChar = "*"
+
+// Mask masks the provided name according the masking policy. Unknown or empty
+// policies are not masking the name.
+func (m NameMasking) Mask(name string) string {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return name
+	}
+
+	switch m {
+	case NameMaskingFull:
+		return strings.Repeat(maskChar, 3)
+	case NameMaskingInitials:
+		initials := make([]string, 0, len(words))
+		for _, w := range words {
+			r, _ := utf8.DecodeRuneInString(w)
+			initials = append(initials, string(r)+".")
+		}
+		return strings.Join(initials, " ")
+	case NameMaskingFirstName:
+		masked := []string{words[0]}
+		for _, w := range words[1:] {
+			r, size := utf8.DecodeRuneInString(w)
+			masked = append(masked, string(r)+strings.Repeat(maskChar, utf8.RuneCountInString(w[size:])))
+		}
+		return strings.Join(masked, " ")
+	}
+	return name
+}
+
+// MaskIDN masks the provided IDN by keeping only its last 4 symbols visible.
+func MaskIDN(idn string) string {
+	runes := []rune(idn)
+	if len(runes) <= 4 {
+		return idn
+	}
+	return strings.Repeat(maskChar, len(runes)-4) + string(runes[len(runes)-4:])
+}
@@ -0,0 +1,43 @@
+package epay
+
+import "testing"
+
+func TestMaskName(t *testing.T) {
+	cases := []struct {
+		policy NameMasking
+		name   string
+		want   string
+	}{
+		{NameMaskingNone, "ЕРДОАН ЕФРАИМОВ ЕФРАИМОВ", "ЕРДОАН ЕФРАИМОВ ЕФРАИМОВ"},
+		{"", "John Smith", "John Smith"},
+		{NameMaskingFull, "ЕРДОАН ЕФРАИМОВ", "***"},
+		{NameMaskingInitials, "ЕРДОАН ЕФРАИМОВ ЕФРАИМОВ", "Е. Е. Е."},
+		{NameMaskingInitials, "John  Smith", "J. S."},
+		{NameMaskingFirstName, "Иван Петров", "Иван П*****"},
+		{NameMaskingFirstName, "Иван", "Иван"},
+		{NameMaskingFull, "", ""},
+	}
+
+	for _, c := range cases {
+		if got := c.policy.Mask(c.name); got != c.want {
+			t.Errorf("%s.Mask(%q) = %q, want: %q", c.policy, c.name, got, c.want)
+		}
+	}
+}
+
+func TestMaskIDN(t *testing.T) {
+	cases := []struct {
+		idn  string
+		want string
+	}{
+		{"1234567", "***4567"},
+		{"123", "123"},
+		{"АБВГДЕ", "**ВГДЕ"},
+	}
+
+	for _, c := range cases {
+		if got := MaskIDN(c.idn); got != c.want {
+			t.Errorf("MaskIDN(%q) = %q, want: %q", c.idn, got, c.want)
+		}
+	}
+}
@@ -22,6 +22,11 @@ type BillResponse struct {
 	Successful        bool
 	UnknownSubscriber bool
 	Amount            int
+
+	// ShortDesc and LongDesc are optional descriptions of the bill. Gateways are responsible
+	// for masking of any personal data in them.
+	ShortDesc string
+	LongDesc  string
 }
 
 // Status gets bill response status
@@ -110,18 +115,22 @@ func (s *Server) handle(c io.ReadWriteCloser, gateway Gateway) {
 
 	if req.IsForBillCheck() {
 		if cb, err := gateway.GetCurrentBill(req.CustomerID, req.TransactionID); err != nil {
-			resp = &billResponse{Status: CommonError}
-		} else {
 			log.Printf("unable to call billing due: %v", err)
-			resp = &billResponse{Amount: cb.Amount, Status: cb.Status()}
+			resp = &billResponse{Status: ErrorStatus(OperationInit, err)}
+		} else {
+			resp = &billResponse{Amount: cb.Amount, Status: cb.Status(), ShortDesc: cb.ShortDesc, LongDesc: cb.LongDesc}
 		}
 	} else if req.IsForPayment() {
 		pr, err := gateway.PayBill(req.CustomerID, req.TransactionID, req.Amount)
 		if err != nil {
-			resp = &paymentResponse{CommonError}
+			log.Printf("unable to pay bill due: %v", err)
+			resp = &paymentResponse{ErrorStatus(OperationConfirm, err)}
 		} else {
 			resp = &paymentResponse{pr.Status()}
 		}
+	} else {
+		log.Printf("got not supported request type: %s", req.Type)
+		resp = &paymentResponse{StatusCommonError}
 	}
 
 	resp.Write(c)
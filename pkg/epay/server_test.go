@@ -23,6 +23,21 @@ func TestGetCurrentBill(t *testing.T) {
 	}
 }
 
+func TestGetCurrentBillWithDescription(t *testing.T) {
+	s := NewServer()
+	defer s.Stop()
+	l, _ := net.Listen("tcp", ":0")
+	go s.Serve(l, &fakeGateway{billResponse: &BillResponse{Successful: true, Amount: 360, ShortDesc: "Абонатен номер: ***4567", LongDesc: "Клиент: И. П."}, err: nil})
+
+	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
+	defer tearDown()
+	response := epayServer.GetCurrentBill("1234567", "T1")
+	if exp := "XTYPE=RBN\nXVALIDTO=\nAMOUNT=360\nSTATUS=00\nSHORTDESC=Абонатен номер: ***4567\nLONGDESC=Клиент: И. П.\n"; exp != response {
+		t.Errorf("expected: %s", exp)
+		t.Errorf("     got: %s", response)
+	}
+}
+
 func TestGetCurrentBillFails(t *testing.T) {
 	s := NewServer()
 	defer s.Stop()
@@ -53,6 +68,21 @@ func TestGatewaySendsBrokenRequest(t *testing.T) {
 	}
 }
 
+func TestGatewaySendsNotSupportedRequest(t *testing.T) {
+	s := NewServer()
+	defer s.Stop()
+	l, _ := net.Listen("tcp", ":0")
+	go s.Serve(l, nil)
+
+	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
+	defer tearDown()
+	response := epayServer.DummyRequest("XTYPE=QXX\nIDN=123\n")
+	if exp := "XTYPE=RBC\nSTATUS=96\n"; exp != response {
+		t.Errorf("expected: %s", exp)
+		t.Errorf("     got: %s", response)
+	}
+}
+
 func TestPayBill(t *testing.T) {
 	s := NewServer()
 	defer s.Stop()
@@ -0,0 +1,34 @@
+package epay
+
+// Status is representing the response status which is returned
+// back to the epay processor
+type Status string
+
+// The catalog of statuses which are returned back to ePay. It's shared between
+// the HTTP and the TCP integrations.
+const (
+	// StatusSuccess indicates the success of the requested operation
+	StatusSuccess Status = "00"
+	// StatusSubscriberNotFound indicates that unknown subscriber was requested
+	StatusSubscriberNotFound Status = "14"
+	// StatusNoDuties indicates that subscriber has no duties
+	StatusNoDuties Status = "62"
+	// StatusTemporaryNotAvailable is indicating that service is temporary not available
+	StatusTemporaryNotAvailable Status = "80"
+	// StatusBadChecksum is indicating that received request is with bad checksum
+	StatusBadChecksum Status = "93"
+	// StatusAlreadyPaid is indicating the duty is already paid
+	StatusAlreadyPaid Status = "94"
+	// StatusCommonError indicates a common error
+	StatusCommonError Status = "96"
+)
+
+// Known checks whether the status is part of the catalog of statuses.
+func (s Status) Known() bool {
+	switch s {
+	case StatusSuccess, StatusSubscriberNotFound, StatusNoDuties, StatusTemporaryNotAvailable,
+		StatusBadChecksum, StatusAlreadyPaid, StatusCommonError:
+		return true
+	}
+	return false
+}
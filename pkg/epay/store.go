@@ -1,6 +1,9 @@
 package epay
 
-import "context"
+import (
+	"context"
+	"log"
+)
 
 // EnvironmentStore is an interface used for retrieving of the environment.
 type EnvironmentStore interface {
@@ -8,3 +11,37 @@ type EnvironmentStore interface {
 	// Get gets the environment configuration of the provided name.
 	Get(ctx context.Context, name string) (*Environment, error)
 }
+
+// EnvironmentRegistry is an EnvironmentStore which allows management of the environments.
+type EnvironmentRegistry interface {
+	EnvironmentStore
+
+	// List lists the names of all environments.
+	List(ctx context.Context) ([]string, error)
+
+	// Put creates or replaces the environment with the provided name.
+	Put(ctx context.Context, name string, env *Environment) error
+}
+
+// NewValidatingStore creates a new EnvironmentStore which validates the environments
+// loaded from the provided store, so invalid configuration is reported when it's loaded
+// instead of when the billing is called. Invalid environments are only logged, as they
+// are rejected at startup and by the admin API, while the serving should not be stopped.
+func NewValidatingStore(store EnvironmentStore) EnvironmentStore {
+	return &validatingStore{store}
+}
+
+type validatingStore struct {
+	store EnvironmentStore
+}
+
+func (s *validatingStore) Get(ctx context.Context, name string) (*Environment, error) {
+	env, err := s.store.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.Validate(); err != nil {
+		log.Printf("warning: environment '%s' is not valid: %v", name, err)
+	}
+	return env, nil
+}
@@ -7,9 +7,41 @@ import (
 	"fmt"
 	"net/url"
 	"sort"
+	"strings"
+	"time"
 )
 
-// Checksum calculates the Values checksum using the epay specific format.
+// Secret is a secret issued by ePay for verification of the checksums. Secrets
+// have an optional validity window which allows rotation of secrets without downtime.
+type Secret struct {
+	// ID is the identifier of the secret which is reported when secret is matched.
+	ID string `json:"id"`
+
+	// Value is the secret value.
+	Value string `json:"value"`
+
+	// NotBefore is the time from which the secret is active. Secret is active
+	// from the beginning of time when it's not provided.
+	NotBefore time.Time `json:"notBefore,omitempty"`
+
+	// NotAfter is the time after which the secret is no longer active. Secret never
+	// expires when it's not provided.
+	NotAfter time.Time `json:"notAfter,omitempty"`
+}
+
+// ActiveAt checks whether the secret is active at the provided time.
+func (s Secret) ActiveAt(t time.Time) bool {
+	if !s.NotBefore.IsZero() && t.Before(s.NotBefore) {
+		return false
+	}
+	if !s.NotAfter.IsZero() && t.After(s.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// Checksum calculates the Values checksum using the epay specific format. Parameters
+// having multiple values are included once per value in the order of their values.
 func Checksum(q url.Values, secret string) string {
 	keys := make([]string, 0, len(q))
 	for k := range q {
@@ -20,13 +52,32 @@ func Checksum(q url.Values, secret string) string {
 	}
 
 	sort.Strings(keys)
-	message := ""
+	var message strings.Builder
 	for _, k := range keys {
-		message += fmt.Sprintf("%s%s\n", k, q[k][0])
+		for _, v := range q[k] {
+			message.WriteString(fmt.Sprintf("%s%s\n", k, v))
+		}
 	}
 
 	key := []byte(secret)
 	h := hmac.New(sha1.New, key)
-	h.Write([]byte(message))
+	h.Write([]byte(message.String()))
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+// VerifyChecksum verifies the provided checksum of the values against each of the provided
+// secrets using constant time comparison. The matching secret is returned or nil if
+// checksum is not matching any of the secrets.
+func VerifyChecksum(q url.Values, checksum string, secrets []Secret) *Secret {
+	var matched *Secret
+	got := []byte(strings.ToLower(checksum))
+
+	// all secrets are checked to not reveal which of them is matching
+	for i := range secrets {
+		want := []byte(Checksum(q, secrets[i].Value))
+		if hmac.Equal(want, got) && matched == nil {
+			matched = &secrets[i]
+		}
+	}
+	return matched
+}
@@ -3,6 +3,7 @@ package epay
 import (
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestSum(t *testing.T) {
@@ -21,3 +22,64 @@ func TestSum(t *testing.T) {
 		t.Errorf("                           but was: %s", cs)
 	}
 }
+
+func TestSumOfMultipleValues(t *testing.T) {
+	values := url.Values{
+		"IDN":        []string{"::idn::"},
+		"MERCHANTID": []string{"MARCHANTID"},
+	}
+	multiValues := url.Values{
+		"IDN":        []string{"::idn::", "::idn2::"},
+		"MERCHANTID": []string{"MARCHANTID"},
+	}
+
+	if Checksum(values, "mysecret") == Checksum(multiValues, "mysecret") {
+		t.Errorf("expected all values to be included in the checksum")
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	values := url.Values{
+		"IDN":        []string{"::idn::"},
+		"MERCHANTID": []string{"MARCHANTID"},
+	}
+	secrets := []Secret{
+		{ID: "old", Value: "oldsecret"},
+		{ID: "new", Value: "mysecret"},
+	}
+
+	matched := VerifyChecksum(values, "c981d4c17f7e01a71d021590e97d57c0a3da21b9", secrets)
+	if matched == nil || matched.ID != "new" {
+		t.Errorf("expected secret 'new' to be matched, but got: %v", matched)
+	}
+
+	if matched := VerifyChecksum(values, "c981d4c17f7e01a71d021590e97d57c0a3da21b0", secrets); matched != nil {
+		t.Errorf("expected no secret to be matched, but got: %v", matched)
+	}
+
+	if matched := VerifyChecksum(values, "", nil); matched != nil {
+		t.Errorf("expected no secret to be matched without secrets, but got: %v", matched)
+	}
+}
+
+func TestSecretActiveAt(t *testing.T) {
+	now := time.Date(2020, time.January, 10, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name   string
+		secret Secret
+		want   bool
+	}{
+		{"without window", Secret{}, true},
+		{"within window", Secret{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}, true},
+		{"not yet active", Secret{NotBefore: now.Add(time.Hour)}, false},
+		{"expired", Secret{NotAfter: now.Add(-time.Hour)}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.secret.ActiveAt(now); got != c.want {
+				t.Errorf("ActiveAt() = %v, want: %v", got, c.want)
+			}
+		})
+	}
+}
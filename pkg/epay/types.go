@@ -21,10 +21,30 @@ var (
 	// when PaymentOrder was already paid
 	ErrPaymentOrderAlreadyPaid = errors.New("payment order was already paid")
 
+	// ErrPaymentOrderExpired is the error used during payment
+	// when PaymentOrder has expired
+	ErrPaymentOrderExpired = errors.New("payment order has expired")
+
+	// ErrPaymentInProgress is the error used during payment when
+	// PaymentOrder is paid by another confirmation
+	ErrPaymentInProgress = errors.New("payment of the order is in progress")
+
 	// ErrSubscriberNotFound is the error used for indication when
 	// subscriber was not found
 	ErrSubscriberNotFound = errors.New("the requested subscriber was not found")
 
+	// ErrSubscriberAmbiguous is the error used when the subscriber
+	// is matching several customers of the billing backend
+	ErrSubscriberAmbiguous = errors.New("the requested subscriber is matching several customers")
+
+	// ErrEnvironmentNotFound is the error used when the requested
+	// environment was not found
+	ErrEnvironmentNotFound = errors.New("environment was not found")
+
+	// ErrBackendUnavailable is the error used when the billing backend
+	// is temporarily not available
+	ErrBackendUnavailable = errors.New("billing backend is temporarily not available")
+
 	// ErrUnknown is the error which is return when no known cases
 	// are recognized by the code
 	ErrUnknown = errors.New("unknown error")
@@ -47,12 +67,90 @@ type Environment struct {
 	// of the Checksum using HMAC SHA1 encoded as HEX
 	EpaySecret string
 
+	// EpaySecrets is a list of additional secrets provided from ePay, each with an optional
+	// validity window, which allows rotation of the secret without downtime.
+	EpaySecrets []Secret
+
 	// MerchantID is the identifier of the merchant which was issued by ePay
 	// provider
 	MerchantID string
 
 	// Metadata is a set of key-value pairs keeping for keeping of internal metadata attributes
 	Metadata map[string]string
+
+	// NameMasking is the policy used for masking of customer names in the descriptions
+	// returned to ePay and in the logs.
+	NameMasking NameMasking
+
+	// IDNMasking indicates whether IDN should be masked in the descriptions returned to ePay
+	// and in the logs.
+	IDNMasking bool
+
+	// IDNRules is a list of rules for IDNs which are ignored or denied without
+	// calling of the billing backend.
+	IDNRules []IDNRule
+
+	// CircuitBreaker is the configuration of the circuit breakers of the billing
+	// backends. Default settings are used when it's not provided.
+	CircuitBreaker *CircuitBreaker
+}
+
+// CircuitBreaker is the configuration of the circuit breakers of the billing backends.
+type CircuitBreaker struct {
+	// Disabled disables the circuit breakers of the environment.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// FailureThreshold is the number of consecutive failures which opens the circuit.
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+
+	// OpenSeconds is the number of seconds for which the circuit stays open before it's probed.
+	OpenSeconds int `json:"openSeconds,omitempty"`
+
+	// HalfOpenRequests is the number of probe requests which should succeed to close the circuit.
+	HalfOpenRequests int `json:"halfOpenRequests,omitempty"`
+}
+
+// ActiveSecrets gets all ePay secrets of the environment which are active at the provided time.
+func (e *Environment) ActiveSecrets(t time.Time) []Secret {
+	secrets := make([]Secret, 0, len(e.EpaySecrets)+1)
+	if e.EpaySecret != "" {
+		secrets = append(secrets, Secret{ID: "epaySecret", Value: e.EpaySecret})
+	}
+	for _, s := range e.EpaySecrets {
+		if s.ActiveAt(t) {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets
+}
+
+// MatchIDNRule gets the first IDN rule of the environment that matches the provided IDN
+// for the requested operation. Nil is returned when no rule is matching the IDN. The
+// DefaultIDNRules are used when the environment has no IDN rules.
+func (e *Environment) MatchIDNRule(op Operation, idn string) *IDNRule {
+	rules := e.IDNRules
+	if rules == nil {
+		rules = DefaultIDNRules
+	}
+	for i, r := range rules {
+		if r.Matches(op, idn) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// DisplayName gets the customer name masked according the masking policy of the environment.
+func (e *Environment) DisplayName(name string) string {
+	return e.NameMasking.Mask(name)
+}
+
+// DisplayIDN gets the IDN masked according the masking policy of the environment.
+func (e *Environment) DisplayIDN(idn string) string {
+	if e.IDNMasking {
+		return MaskIDN(idn)
+	}
+	return idn
 }
 
 // SubscriberDuties represents duties of the subscriber
@@ -106,7 +204,11 @@ type Item struct {
 	Amount    Amount    `json:"amount"`
 	Vat       float64   `json:"vat"`
 	Price     string    `json:"price"`
-	Quantity  int       `json:"quantity"`
+	Quantity  float64   `json:"quantity"`
+
+	// InvoiceNumber and DueDate are the number and the due date of the invoice of the item.
+	InvoiceNumber string    `json:"invoiceNumber"`
+	DueDate       time.Time `json:"dueDate"`
 }
 
 // Amount represents Order amount
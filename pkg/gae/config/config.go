@@ -1,3 +1,7 @@
+// Package config is a compatibility shim for applications which are running on the legacy
+// App Engine runtime.
+//
+// Deprecated: use epay.EnvironmentStore created by db.NewEnvironmentStore instead.
 package config
 
 import (
@@ -6,9 +10,14 @@ import (
 	"strings"
 
 	"google.golang.org/appengine/datastore"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server/db"
 )
 
 // Environment is representing a single environment in the context of the application.
+//
+// Deprecated: use epay.Environment instead.
 type Environment struct {
 	// The Billing JWT Key as string value. This key is issued
 	// from iam.telcong.com and is available for everyone that has clouway account
@@ -27,8 +36,22 @@ type Environment struct {
 	MerchantID string
 }
 
+// Environment converts the legacy environment to epay.Environment.
+func (e *Environment) Environment() *epay.Environment {
+	return &epay.Environment{
+		BillingJWTKey: e.BillingJWTKey,
+		BillingKey:    e.BillingJWTKey,
+		BillingURL:    e.BillingURL,
+		EpaySecret:    e.EpaySecret,
+		MerchantID:    e.MerchantID,
+	}
+}
+
 // GetEnv gets the configuration environment associated with the provided name. The GetEnv
 // tries to return the default environment if it detects that target hostname is for GAE.
+// Environments are read in both the legacy and the current layout of the properties.
+//
+// Deprecated: use epay.EnvironmentStore created by db.NewEnvironmentStore instead.
 func GetEnv(ctx context.Context, name string) (*Environment, error) {
 
 	// A default name should be used
@@ -37,16 +60,29 @@ func GetEnv(ctx context.Context, name string) (*Environment, error) {
 	}
 
 	key := datastore.NewKey(ctx, "Environment", name, 0, nil)
-	e := new(environmentEntity)
-	if err := datastore.Get(ctx, key, e); err != nil {
+	var ps datastore.PropertyList
+	if err := datastore.Get(ctx, key, &ps); err != nil {
 		return nil, fmt.Errorf("could not load the environment '%s' due: %v", name, err)
 	}
-	return &Environment{BillingJWTKey: e.BillingKey, BillingURL: e.BillingURL, EpaySecret: e.EpaySecret, MerchantID: e.MerchantID}, nil
-}
 
-type environmentEntity struct {
-	BillingKey string `datastore:"billingKey"`
-	BillingURL string `datastore:"billingURL"`
-	EpaySecret string `datastore:"epaySecret"`
-	MerchantID string `datastore:"merchantId"`
+	// Properties of the current layout take precedence over the legacy ones.
+	values := make(map[string]string)
+	for _, p := range ps {
+		v, ok := p.Value.(string)
+		if !ok {
+			continue
+		}
+		canonical := db.CanonicalPropertyName(p.Name)
+		if _, exists := values[canonical]; exists && canonical != p.Name {
+			continue
+		}
+		values[canonical] = v
+	}
+
+	return &Environment{
+		BillingJWTKey: values["BillingKey"],
+		BillingURL:    values["BillingURL"],
+		EpaySecret:    values["EpaySecret"],
+		MerchantID:    values["MerchantID"],
+	}, nil
 }
@@ -0,0 +1,196 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// sealedPrefix is the prefix of the sealed values. Values without it are
+// considered as plaintext values which are not yet encrypted.
+const sealedPrefix = "enc:v1:"
+
+// Envelope encrypts values with a random data encryption key which is then wrapped
+// by the KeyManager and stored next to the encrypted value.
+type Envelope struct {
+	km KeyManager
+}
+
+// NewEnvelope creates a new Envelope which uses the provided KeyManager for
+// wrapping of the data encryption keys.
+func NewEnvelope(km KeyManager) *Envelope {
+	return &Envelope{km: km}
+}
+
+// IsSealed checks whether the provided value is sealed.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Seal encrypts the provided value. Empty and already sealed values are returned as they are.
+func (e *Envelope) Seal(ctx context.Context, value string) (string, error) {
+	if value == "" || IsSealed(value) {
+		return value, nil
+	}
+
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dek, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := e.km.Encrypt(ctx, dek)
+	if err != nil {
+		return "", fmt.Errorf("could not wrap data key due: %v", err)
+	}
+
+	enc := base64.StdEncoding
+	return sealedPrefix + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts the provided value. Values which are not sealed are returned as they are.
+func (e *Envelope) Open(ctx context.Context, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("sealed value is not well formed")
+	}
+	enc := base64.StdEncoding
+	wrapped, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("bad data key encoding: %v", err)
+	}
+	ciphertext, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("bad ciphertext encoding: %v", err)
+	}
+
+	dek, err := e.km.Decrypt(ctx, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("could not unwrap data key due: %v", err)
+	}
+	plaintext, err := open(dek, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt value due: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// metadataSecrets are the keys of the metadata attributes which are holding secrets.
+var metadataSecrets = []string{"apiKey"}
+
+// SealEnvironment encrypts all of the secret fields of the provided environment in place.
+func (e *Envelope) SealEnvironment(ctx context.Context, env *epay.Environment) error {
+	return transform(ctx, env, e.Seal)
+}
+
+// OpenEnvironment decrypts all of the secret fields of the provided environment in place.
+func (e *Envelope) OpenEnvironment(ctx context.Context, env *epay.Environment) error {
+	return transform(ctx, env, e.Open)
+}
+
+// HasPlaintextSecrets checks whether any of the secret fields of the provided
+// environment is not encrypted.
+func HasPlaintextSecrets(env *epay.Environment) bool {
+	found := false
+	c := *env
+	transform(context.Background(), &c, func(ctx context.Context, value string) (string, error) {
+		if value != "" && !IsSealed(value) {
+			found = true
+		}
+		return value, nil
+	})
+	return found
+}
+
+// Redact creates a copy of the provided environment where the values of all secret fields
+// are replaced, so it could be displayed or logged. Sealed values are replaced with a
+// different marker, so it's visible whether they are encrypted.
+func Redact(env *epay.Environment) *epay.Environment {
+	c := *env
+	transform(context.Background(), &c, func(ctx context.Context, value string) (string, error) {
+		switch {
+		case value == "":
+			return value, nil
+		case IsSealed(value):
+			return "[sealed]", nil
+		default:
+			return "[redacted]", nil
+		}
+	})
+	return &c
+}
+
+func transform(ctx context.Context, env *epay.Environment, fn func(context.Context, string) (string, error)) error {
+	var err error
+	if env.EpaySecret, err = fn(ctx, env.EpaySecret); err != nil {
+		return fmt.Errorf("epaySecret: %v", err)
+	}
+	if env.BillingJWTKey, err = fn(ctx, env.BillingJWTKey); err != nil {
+		return fmt.Errorf("billingJWTKey: %v", err)
+	}
+	if env.BillingKey, err = fn(ctx, env.BillingKey); err != nil {
+		return fmt.Errorf("billingKey: %v", err)
+	}
+
+	if len(env.EpaySecrets) > 0 {
+		secrets := make([]epay.Secret, len(env.EpaySecrets))
+		for i, s := range env.EpaySecrets {
+			if s.Value, err = fn(ctx, s.Value); err != nil {
+				return fmt.Errorf("epaySecrets[%s]: %v", s.ID, err)
+			}
+			secrets[i] = s
+		}
+		env.EpaySecrets = secrets
+	}
+
+	if env.Metadata != nil {
+		metadata := make(map[string]string, len(env.Metadata))
+		for k, v := range env.Metadata {
+			metadata[k] = v
+		}
+		for _, k := range metadataSecrets {
+			v, ok := metadata[k]
+			if !ok {
+				continue
+			}
+			if metadata[k], err = fn(ctx, v); err != nil {
+				return fmt.Errorf("metadata[%s]: %v", k, err)
+			}
+		}
+		env.Metadata = metadata
+	}
+	return nil
+}
+
+// NewDecryptingStore creates a new store which transparently decrypts the secrets of the
+// environments which are returned by the provided store.
+func NewDecryptingStore(store epay.EnvironmentStore, e *Envelope) epay.EnvironmentStore {
+	return &decryptingStore{store, e}
+}
+
+type decryptingStore struct {
+	store    epay.EnvironmentStore
+	envelope *Envelope
+}
+
+func (d *decryptingStore) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	env, err := d.store.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.envelope.OpenEnvironment(ctx, env); err != nil {
+		return nil, fmt.Errorf("could not decrypt secrets of environment '%s' due: %v", name, err)
+	}
+	return env, nil
+}
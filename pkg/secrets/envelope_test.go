@@ -0,0 +1,129 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSealAndOpen(t *testing.T) {
+	e := newTestEnvelope(t)
+	ctx := context.Background()
+
+	sealed, err := e.Seal(ctx, "mysecret")
+	if err != nil {
+		t.Fatalf("unable to seal value due: %v", err)
+	}
+	if !IsSealed(sealed) || sealed == "mysecret" {
+		t.Fatalf("expected value to be sealed, but got: %s", sealed)
+	}
+
+	resealed, _ := e.Seal(ctx, sealed)
+	if resealed != sealed {
+		t.Errorf("expected sealed value to not be sealed again")
+	}
+
+	opened, err := e.Open(ctx, sealed)
+	if err != nil {
+		t.Fatalf("unable to open value due: %v", err)
+	}
+	if opened != "mysecret" {
+		t.Errorf("expected opened value to be: mysecret, but got: %s", opened)
+	}
+
+	if plain, _ := e.Open(ctx, "plain"); plain != "plain" {
+		t.Errorf("expected not sealed value to be returned as it is, but got: %s", plain)
+	}
+}
+
+func TestOpenWithAnotherKey(t *testing.T) {
+	ctx := context.Background()
+	sealed, _ := newTestEnvelope(t).Seal(ctx, "mysecret")
+
+	km, _ := NewLocalKeyManager(bytes.Repeat([]byte{2}, 32))
+	if _, err := NewEnvelope(km).Open(ctx, sealed); err == nil {
+		t.Errorf("expected value to not be opened with another key")
+	}
+}
+
+func TestDecryptingStore(t *testing.T) {
+	e := newTestEnvelope(t)
+	ctx := context.Background()
+
+	want := &epay.Environment{
+		BillingJWTKey: "{}",
+		BillingKey:    "{}",
+		EpaySecret:    "mysecret",
+		EpaySecrets:   []epay.Secret{{ID: "new", Value: "newsecret"}},
+		MerchantID:    "M1",
+		Metadata:      map[string]string{"apiKey": "key", "billingUrl": "http://ucrm"},
+	}
+
+	sealed := *want
+	if err := e.SealEnvironment(ctx, &sealed); err != nil {
+		t.Fatalf("unable to seal environment due: %v", err)
+	}
+	if sealed.EpaySecret == want.EpaySecret || sealed.EpaySecrets[0].Value == "newsecret" || sealed.Metadata["apiKey"] == "key" {
+		t.Fatalf("expected secrets to be sealed, but got: %+v", sealed)
+	}
+	if want.Metadata["apiKey"] != "key" || want.EpaySecrets[0].Value != "newsecret" {
+		t.Fatalf("expected source environment to not be modified")
+	}
+
+	if HasPlaintextSecrets(&sealed) || !HasPlaintextSecrets(want) {
+		t.Errorf("expected only the source environment to have plaintext secrets")
+	}
+
+	got, err := NewDecryptingStore(fakeStore{&sealed}, e).Get(ctx, "default")
+	if err != nil {
+		t.Fatalf("unable to get environment due: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal("unexpected environment (-want +got): ", diff)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	e := newTestEnvelope(t)
+	sealed, _ := e.Seal(context.Background(), "key")
+
+	env := &epay.Environment{
+		EpaySecret:  "mysecret",
+		EpaySecrets: []epay.Secret{{ID: "new", Value: "newsecret"}},
+		MerchantID:  "M1",
+		Metadata:    map[string]string{"apiKey": sealed, "billingUrl": "http://ucrm"},
+	}
+
+	want := &epay.Environment{
+		EpaySecret:  "[redacted]",
+		EpaySecrets: []epay.Secret{{ID: "new", Value: "[redacted]"}},
+		MerchantID:  "M1",
+		Metadata:    map[string]string{"apiKey": "[sealed]", "billingUrl": "http://ucrm"},
+	}
+	if diff := cmp.Diff(want, Redact(env)); diff != "" {
+		t.Error("unexpected environment (-want +got): ", diff)
+	}
+	if env.EpaySecret != "mysecret" || env.Metadata["apiKey"] != sealed {
+		t.Errorf("expected source environment to not be modified")
+	}
+}
+
+func newTestEnvelope(t *testing.T) *Envelope {
+	km, err := NewLocalKeyManager(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("unable to create key manager due: %v", err)
+	}
+	return NewEnvelope(km)
+}
+
+type fakeStore struct {
+	env *epay.Environment
+}
+
+func (f fakeStore) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	env := *f.env
+	return &env, nil
+}
@@ -0,0 +1,180 @@
+// Package secrets provides envelope encryption of the secrets which are stored in
+// the environments.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+const cloudKMSScope = "https://www.googleapis.com/auth/cloudkms"
+
+// KeyManager is an interface of a key encryption key which is used for wrapping
+// of the data encryption keys.
+type KeyManager interface {
+	// Encrypt encrypts the provided plaintext.
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+
+	// Decrypt decrypts the provided ciphertext.
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// NewLocalKeyManager creates a new KeyManager which uses the provided 256 bit key for
+// encryption with AES-GCM. It's suitable for development and on-prem setups.
+func NewLocalKeyManager(key []byte) (KeyManager, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key should be 32 bytes long, but was %d bytes", len(key))
+	}
+	return &localKeyManager{key: key}, nil
+}
+
+// LoadKeyFile creates a new local KeyManager using the key from the provided file. The
+// key is expected to be base64 encoded.
+func LoadKeyFile(file string) (KeyManager, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file '%s' due: %v", file, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("key file '%s' is not base64 encoded: %v", file, err)
+	}
+	return NewLocalKeyManager(key)
+}
+
+type localKeyManager struct {
+	key []byte
+}
+
+func (l *localKeyManager) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	return seal(l.key, plaintext)
+}
+
+func (l *localKeyManager) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	return open(l.key, ciphertext)
+}
+
+// NewCloudKMS creates a new KeyManager which uses the provided key of Google Cloud KMS. The
+// keyName is the resource name of the key, e.g
+// projects/{project}/locations/{location}/keyRings/{keyRing}/cryptoKeys/{key}. The provided
+// client should be authorized with the cloudkms scope.
+func NewCloudKMS(client *http.Client, keyName string) KeyManager {
+	return &cloudKMS{client: client, baseURL: "https://cloudkms.googleapis.com/v1/", keyName: keyName}
+}
+
+type cloudKMS struct {
+	client  *http.Client
+	baseURL string
+	keyName string
+}
+
+func (c *cloudKMS) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	req := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}
+	if err := c.call(ctx, "encrypt", req, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+func (c *cloudKMS) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	req := map[string]string{"ciphertext": base64.StdEncoding.EncodeToString(ciphertext)}
+	if err := c.call(ctx, "decrypt", req, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
+
+func (c *cloudKMS) call(ctx context.Context, method string, body, v interface{}) error {
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(body); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+c.keyName+":"+method, buf)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not call kms %s due: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kms %s failed with status: %s", method, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// seal encrypts the plaintext with AES-GCM using the provided key. The random nonce
+// is prepended to the ciphertext.
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewKeyManager creates a KeyManager from the provided key file or the resource name of
+// Cloud KMS key. Nil is returned when none of them is provided.
+func NewKeyManager(ctx context.Context, keyFile, kmsKeyName string) (KeyManager, error) {
+	switch {
+	case keyFile != "" && kmsKeyName != "":
+		return nil, fmt.Errorf("only one of key file or kms key should be provided")
+	case keyFile != "":
+		return LoadKeyFile(keyFile)
+	case kmsKeyName != "":
+		client, err := google.DefaultClient(ctx, cloudKMSScope)
+		if err != nil {
+			return nil, fmt.Errorf("could not create kms client due: %v", err)
+		}
+		return NewCloudKMS(client, kmsKeyName), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,39 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/clouway/go-epay/pkg/client/breaker"
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+// HealthResponse is the response of the health check.
+type HealthResponse struct {
+	Status string `json:"status"`
+
+	// OpenCircuits are the keys of the circuit breakers which are not closed.
+	OpenCircuits []string `json:"openCircuits"`
+}
+
+// Health creates a new handler which responds with the health of the service and the
+// circuit breakers of the billing backends which are not closed. The service is healthy
+// even if some of the backends are not available.
+func Health(breakers *breaker.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := &HealthResponse{Status: "ok", OpenCircuits: []string{}}
+		for _, s := range breakers.Statuses() {
+			if s.State != breaker.StateClosed {
+				resp.OpenCircuits = append(resp.OpenCircuits, s.Key)
+			}
+		}
+		httputil.RespondWithJSON(r.Context(), w, resp)
+	})
+}
+
+// Breakers creates a new handler which responds with the state and the counters of
+// all circuit breakers.
+func Breakers(breakers *breaker.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httputil.RespondWithJSON(r.Context(), w, breakers.Statuses())
+	})
+}
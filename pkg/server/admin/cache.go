@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/server/envcache"
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+// EnvironmentCache is the cache of environments which is managed through the admin API.
+type EnvironmentCache interface {
+	// Invalidate removes the environment with the provided name from the cache.
+	Invalidate(name string)
+
+	// InvalidateAll removes all environments from the cache.
+	InvalidateAll()
+
+	// Stats gets the statistics of the cache usage.
+	Stats() envcache.Stats
+}
+
+// InvalidateCache creates a new handler which invalidates the cached environment of the
+// tenant provided as query parameter or all environments when no tenant is provided.
+func InvalidateCache(cache EnvironmentCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		name := r.URL.Query().Get("tenant")
+
+		if name == "" {
+			cache.InvalidateAll()
+			log.WithContext(ctx).Printf("all cached environments were invalidated")
+		} else {
+			cache.Invalidate(name)
+			log.WithContext(ctx).Printf("cached environment of '%s' was invalidated", name)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// CacheStats creates a new handler which responds with the statistics of the cache usage.
+func CacheStats(cache EnvironmentCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httputil.RespondWithJSON(r.Context(), w, cache.Stats())
+	})
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server/envstore"
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+// Sealer seals the secrets of the environment before it's stored.
+type Sealer interface {
+	// SealEnvironment seals the secrets of the provided environment in place.
+	SealEnvironment(ctx context.Context, env *epay.Environment) error
+}
+
+// ValidationResponse is the response of the validation of an environment.
+type ValidationResponse struct {
+	Valid  bool   `json:"valid"`
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// PutEnvironment creates a new handler which validates and stores the environment of the
+// tenant in the path. The environment is received as JSON document in the body of the request
+// and it's secrets are sealed when sealer is provided.
+func PutEnvironment(registry epay.EnvironmentRegistry, sealer Sealer, cache EnvironmentCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		name := mux.Vars(r)["tenant"]
+
+		doc := &envstore.Document{}
+		if err := json.NewDecoder(r.Body).Decode(doc); err != nil {
+			http.Error(w, "environment is not a valid JSON document", http.StatusBadRequest)
+			return
+		}
+		env, err := doc.Environment(name)
+		if err == nil {
+			err = env.Validate()
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(validationResponse(err))
+			return
+		}
+
+		if sealer != nil {
+			if err := sealer.SealEnvironment(ctx, env); err != nil {
+				log.WithContext(ctx).Errorf("could not seal environment '%s' due: %v", name, err)
+				http.Error(w, "could not seal environment", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := registry.Put(ctx, name, env); err != nil {
+			log.WithContext(ctx).Errorf("could not store environment due: %v", err)
+			http.Error(w, "could not store environment", http.StatusInternalServerError)
+			return
+		}
+		cache.Invalidate(name)
+
+		log.WithContext(ctx).Printf("environment '%s' was updated", name)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// ValidateEnvironment creates a new handler which validates the stored environment
+// of the tenant in the path.
+func ValidateEnvironment(store epay.EnvironmentStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		name := mux.Vars(r)["tenant"]
+
+		env, err := store.Get(ctx, name)
+		if errors.Is(err, epay.ErrEnvironmentNotFound) {
+			http.Error(w, "environment was not found", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			err = env.Validate()
+		}
+		httputil.RespondWithJSON(ctx, w, validationResponse(err))
+	})
+}
+
+func validationResponse(err error) *ValidationResponse {
+	if err == nil {
+		return &ValidationResponse{Valid: true}
+	}
+
+	var configErr *epay.ConfigError
+	if errors.As(err, &configErr) {
+		return &ValidationResponse{Field: configErr.Field, Reason: configErr.Reason}
+	}
+	return &ValidationResponse{Reason: err.Error()}
+}
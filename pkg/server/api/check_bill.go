@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -23,9 +24,25 @@ func CheckBill(cf epay.ClientFactory) http.Handler {
 		ctx := r.Context()
 		contextLogger := log.WithContext(ctx)
 
-		env := r.Context().Value(server.EnvironmentKey).(*epay.Environment)
-		idn := r.URL.Query().Get("IDN")
-		client := cf.Create(ctx, *env, idn)
+		env, ok := server.EnvironmentFromContext(ctx)
+		if !ok {
+			contextLogger.Printf("no environment is associated with the request")
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+			return
+		}
+		req, err := ParseRequest(r)
+		if err != nil {
+			contextLogger.Printf("could not parse request due: %v", err)
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+			return
+		}
+		idn := req.IDN
+		client, err := cf.Create(ctx, *env, idn)
+		if err != nil {
+			contextLogger.Errorf("could not create billing client due: %v", err)
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+			return
+		}
 
 		var response *DutyResponse
 
@@ -37,26 +54,28 @@ func CheckBill(cf epay.ClientFactory) http.Handler {
 			if coins == 0 {
 				response = &DutyResponse{Status: StatusNoDuties}
 			} else {
-				contextLogger.Printf("checking bill of idn: %v", res.Items)
-				response = successResponse(idn, res.CustomerName, res.Items, coins)
+				contextLogger.Printf("checking bill of customer '%s' with items: %v", env.DisplayName(res.CustomerName), res.Items)
+				response = successResponse(env, idn, res.CustomerName, res.Items, coins)
 			}
-		} else if err == epay.ErrSubscriberNotFound {
-			contextLogger.Printf("subscriber '%s' was not found", idn)
-			// not valid idn number
-			response = &DutyResponse{Status: StatusSubscriberNotFound}
+		} else if errors.Is(err, epay.ErrSubscriberNotFound) {
+			contextLogger.Printf("subscriber '%s' was not found", env.DisplayIDN(idn))
+			response = &DutyResponse{Status: epay.ErrorStatus(epay.OperationCheck, err)}
 		} else {
-			contextLogger.Printf("got unknown error: %v", err)
-			// temporary not available
-			response = &DutyResponse{Status: StatusTemporaryNotAvailable}
+			contextLogger.Printf("could not get subscriber duties due: %v", err)
+			response = &DutyResponse{Status: epay.ErrorStatus(epay.OperationCheck, err)}
 		}
 
 		httputil.RespondWithJSON(ctx, w, response)
 	})
 }
 
-func successResponse(subscriberID, customerName string, items []epay.Item, coins int) *DutyResponse {
-	shortDesc := "Абонатен номер: " + subscriberID
-	return &DutyResponse{IDN: subscriberID, Status: "00", ShortDesc: shortDesc, LongDesc: buildLongDesc(customerName, subscriberID, items), Amount: coins}
+// successResponse builds the response of the found duties. The customer name and the IDN in
+// the descriptions are masked according the masking policy of the environment.
+func successResponse(env *epay.Environment, subscriberID, customerName string, items []epay.Item, coins int) *DutyResponse {
+	displayID := env.DisplayIDN(subscriberID)
+	shortDesc := truncate("Абонатен номер: "+displayID, shortDescMaxLen)
+	longDesc := buildLongDesc(env.DisplayName(customerName), displayID, items)
+	return &DutyResponse{IDN: subscriberID, Status: StatusSuccess, ShortDesc: shortDesc, LongDesc: longDesc, Amount: coins}
 }
 
 func buildLongDesc(customerName string, subscriberID string, items []epay.Item) string {
@@ -72,8 +91,37 @@ func buildLongDesc(customerName string, subscriberID string, items []epay.Item)
 	}
 
 	longDesc := fmt.Sprintf("Клиент: %s, Абонатен Номер: %s, Детайли: %s", customerName, subscriberID, strings.Join(lines, ","))
-	if len(longDesc) > longDescMaxLen {
-		longDesc = longDesc[0:longDescMaxLen]
+	if invoices := invoiceLines(items); len(invoices) > 0 {
+		longDesc += ", Фактури: " + strings.Join(invoices, ",")
+	}
+	return truncate(longDesc, longDescMaxLen)
+}
+
+// invoiceLines gets the numbers of the invoices of the items together with their due dates.
+func invoiceLines(items []epay.Item) []string {
+	lines := []string{}
+	dup := make(map[string]bool)
+	for _, item := range items {
+		if item.InvoiceNumber == "" || dup[item.InvoiceNumber] {
+			continue
+		}
+		dup[item.InvoiceNumber] = true
+
+		line := item.InvoiceNumber
+		if !item.DueDate.IsZero() {
+			line += " с падеж " + item.DueDate.Format("02.01.2006")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// truncate truncates the provided value to maxLen symbols without breaking
+// of multi-byte symbols.
+func truncate(value string, maxLen int) string {
+	runes := []rune(value)
+	if len(runes) > maxLen {
+		return string(runes[0:maxLen])
 	}
-	return longDesc
+	return value
 }
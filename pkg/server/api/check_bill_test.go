@@ -2,6 +2,7 @@ package api
 
 import (
 	"testing"
+	"time"
 
 	"github.com/clouway/go-epay/pkg/epay"
 	"github.com/google/go-cmp/cmp"
@@ -10,6 +11,7 @@ import (
 func TestSuccessResponse(t *testing.T) {
 	cases := []struct {
 		name         string
+		env          *epay.Environment
 		subscriberID string
 		customerName string
 		items        []epay.Item
@@ -18,6 +20,7 @@ func TestSuccessResponse(t *testing.T) {
 	}{
 		{
 			name:         "short desc is limited",
+			env:          &epay.Environment{},
 			subscriberID: "1234567",
 			customerName: "ЕРДОАН ЕФРАИМОВ ЕФРАИМОВ",
 			cents:        100,
@@ -29,11 +32,72 @@ func TestSuccessResponse(t *testing.T) {
 				Amount:    100,
 			},
 		},
+		{
+			name:         "customer name is masked to initials",
+			env:          &epay.Environment{NameMasking: epay.NameMaskingInitials},
+			subscriberID: "1234567",
+			customerName: "ЕРДОАН ЕФРАИМОВ ЕФРАИМОВ",
+			cents:        100,
+			want: &DutyResponse{
+				Status:    "00",
+				IDN:       "1234567",
+				ShortDesc: "Абонатен номер: 1234567",
+				LongDesc:  "Клиент: Е. Е. Е., Абонатен Номер: 1234567, Детайли: ",
+				Amount:    100,
+			},
+		},
+		{
+			name:         "customer name and idn are masked",
+			env:          &epay.Environment{NameMasking: epay.NameMaskingFirstName, IDNMasking: true},
+			subscriberID: "1234567",
+			customerName: "ЕРДОАН ЕФРАИМОВ",
+			cents:        100,
+			want: &DutyResponse{
+				Status:    "00",
+				IDN:       "1234567",
+				ShortDesc: "Абонатен номер: ***4567",
+				LongDesc:  "Клиент: ЕРДОАН Е*******, Абонатен Номер: ***4567, Детайли: ",
+				Amount:    100,
+			},
+		},
+		{
+			name:         "customer name is fully masked",
+			env:          &epay.Environment{NameMasking: epay.NameMaskingFull},
+			subscriberID: "1234567",
+			customerName: "John Smith",
+			cents:        100,
+			want: &DutyResponse{
+				Status:    "00",
+				IDN:       "1234567",
+				ShortDesc: "Абонатен номер: 1234567",
+				LongDesc:  "Клиент: ***, Абонатен Номер: 1234567, Детайли: ",
+				Amount:    100,
+			},
+		},
+		{
+			name:         "invoices with due dates",
+			env:          &epay.Environment{},
+			subscriberID: "1234567",
+			customerName: "John Smith",
+			items: []epay.Item{
+				{Name: "Internet", InvoiceNumber: "2020000101", DueDate: time.Date(2020, 5, 15, 0, 0, 0, 0, time.UTC)},
+				{Name: "Installation", InvoiceNumber: "2020000101", DueDate: time.Date(2020, 5, 15, 0, 0, 0, 0, time.UTC)},
+				{Name: "TV", InvoiceNumber: "2020000102"},
+			},
+			cents: 100,
+			want: &DutyResponse{
+				Status:    "00",
+				IDN:       "1234567",
+				ShortDesc: "Абонатен номер: 1234567",
+				LongDesc:  "Клиент: John Smith, Абонатен Номер: 1234567, Детайли: Internet,Installation,TV, Фактури: 2020000101 с падеж 15.05.2020,2020000102",
+				Amount:    100,
+			},
+		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			got := successResponse(c.subscriberID, c.customerName, c.items, c.cents)
+			got := successResponse(c.env, c.subscriberID, c.customerName, c.items, c.cents)
 
 			if diff := cmp.Diff(c.want, got); diff != "" {
 				t.Fatal("unexpected response (-want +got): ", diff)
@@ -16,24 +16,45 @@ func ConfirmPaymentOrder(cf epay.ClientFactory) http.Handler {
 		ctx := r.Context()
 		contextLogger := log.WithContext(ctx)
 
-		env := ctx.Value(server.EnvironmentKey).(*epay.Environment)
-		idn := r.URL.Query().Get("IDN")
-		client := cf.Create(r.Context(), *env, idn)
+		env, ok := server.EnvironmentFromContext(ctx)
+		if !ok {
+			contextLogger.Printf("no environment is associated with the request")
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+			return
+		}
+		req, err := ParseRequest(r)
+		if err != nil {
+			contextLogger.Printf("could not parse request due: %v", err)
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+			return
+		}
+		idn := req.IDN
+		client, err := cf.Create(r.Context(), *env, idn)
+		if err != nil {
+			contextLogger.Errorf("could not create billing client due: %v", err)
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+			return
+		}
 
-		transactionID := r.URL.Query().Get("TID")
+		transactionID := req.TID
+
+		// the payment is posted with the time of ePay, which is the confirmation time when it's not provided
+		if paidAt, err := req.PaymentTime(); err != nil {
+			contextLogger.Warnf("could not get the payment time due: %v", err)
+		} else if !paidAt.IsZero() {
+			ctx = epay.WithPaymentTime(ctx, paidAt)
+		}
 
 		contextLogger.Printf("Confirming payment order with transaction: %s", transactionID)
 
-		_, err := client.PayPaymentOrder(ctx, transactionID)
+		_, err = client.PayPaymentOrder(ctx, transactionID)
 
 		var response *DutyResponse
 		if err == nil {
 			response = &DutyResponse{Status: StatusSuccess}
-		} else if err == epay.ErrPaymentOrderAlreadyPaid {
-			response = &DutyResponse{Status: StatusAlreadyPaid}
 		} else {
 			contextLogger.Printf("could not confirm order due: %v", err)
-			response = &DutyResponse{Status: StatusCommonError}
+			response = &DutyResponse{Status: epay.ErrorStatus(epay.OperationConfirm, err)}
 		}
 
 		httputil.RespondWithJSON(ctx, w, response)
@@ -15,12 +15,28 @@ func CreatePaymentOrder(cf epay.ClientFactory) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		contextLogger := log.WithContext(ctx)
-		env := r.Context().Value(server.EnvironmentKey).(*epay.Environment)
-		idn := r.URL.Query().Get("IDN")
-		client := cf.Create(ctx, *env, idn)
+		env, ok := server.EnvironmentFromContext(ctx)
+		if !ok {
+			contextLogger.Printf("no environment is associated with the request")
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+			return
+		}
+		req, err := ParseRequest(r)
+		if err != nil {
+			contextLogger.Printf("could not parse request due: %v", err)
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+			return
+		}
+		idn := req.IDN
+		client, err := cf.Create(ctx, *env, idn)
+		if err != nil {
+			contextLogger.Errorf("could not create billing client due: %v", err)
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+			return
+		}
 
-		transactionID := r.URL.Query().Get("TID")
-		contextLogger.Printf("IDN: %s, TID: %s", idn, transactionID)
+		transactionID := req.TID
+		contextLogger.Printf("IDN: %s, TID: %s", env.DisplayIDN(idn), transactionID)
 
 		res, err := client.CreatePaymentOrder(ctx, epay.CreatePaymentOrderRequest{SubscriberID: idn, TransactionID: transactionID, PaymentSource: EPAY})
 
@@ -30,15 +46,11 @@ func CreatePaymentOrder(cf epay.ClientFactory) http.Handler {
 			if coins == 0 {
 				response = &DutyResponse{Status: StatusNoDuties}
 			} else {
-				response = successResponse(idn, res.CustomerName, res.Items, coins)
+				response = successResponse(env, idn, res.CustomerName, res.Items, coins)
 			}
-		} else if err == epay.ErrPaymentOrderAlreadyExists {
-			response = &DutyResponse{Status: StatusNoDuties}
-		} else if err == epay.ErrSubscriberNotFound {
-			response = &DutyResponse{Status: StatusSubscriberNotFound}
 		} else {
-			contextLogger.Printf("got error: %v", err)
-			response = &DutyResponse{Status: StatusCommonError}
+			contextLogger.Printf("could not create payment order due: %v", err)
+			response = &DutyResponse{Status: epay.ErrorStatus(epay.OperationInit, err)}
 		}
 
 		httputil.RespondWithJSON(ctx, w, response)
@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+// NotSupported creates a new handler which responds to requests that are not supported,
+// e.g unknown TYPE or path, with a common error status which is expected by ePay.
+func NotSupported() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log.WithContext(ctx).Warnf("got not supported request: %s %s", r.Method, r.URL.Path)
+		httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+	})
+}
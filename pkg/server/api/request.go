@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Request is a single request received from ePay either as GET query string
+// or as POST form-encoded body.
+type Request struct {
+	Type       string
+	IDN        string
+	TID        string
+	Amount     int
+	MerchantID string
+	Checksum   string
+
+	// Values are all of the received values which are covered by the checksum.
+	Values url.Values
+}
+
+// ParseRequest parses the ePay request from the query string and the form-encoded body
+// of the provided HTTP request.
+func ParseRequest(r *http.Request) (*Request, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("could not parse request due: %v", err)
+	}
+
+	req := &Request{
+		Type:       r.Form.Get("TYPE"),
+		IDN:        r.Form.Get("IDN"),
+		TID:        r.Form.Get("TID"),
+		MerchantID: r.Form.Get("MERCHANTID"),
+		Checksum:   r.Form.Get("CHECKSUM"),
+		Values:     r.Form,
+	}
+
+	if amount := r.Form.Get("AMOUNT"); amount != "" {
+		coins, err := strconv.Atoi(amount)
+		if err != nil {
+			return nil, fmt.Errorf("bad AMOUNT '%s': %v", amount, err)
+		}
+		req.Amount = coins
+	}
+
+	return req, nil
+}
+
+// paymentTimeLayout is the layout of the DATE value of the requests.
+const paymentTimeLayout = "20060102150405"
+
+// PaymentTime gets the time of the payment from the DATE value of the request, which is
+// the local time of ePay. Zero time is returned when ePay has not provided the time.
+func (r *Request) PaymentTime() (time.Time, error) {
+	date := r.Values.Get("DATE")
+	if date == "" {
+		return time.Time{}, nil
+	}
+	loc, err := time.LoadLocation("Europe/Sofia")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not load time zone of ePay due: %v", err)
+	}
+	t, err := time.ParseInLocation(paymentTimeLayout, date, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad DATE '%s': %v", date, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRequest(t *testing.T) {
+	cases := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		want        *Request
+		wantErr     bool
+		contentType string
+	}{
+		{
+			name:   "query string",
+			method: "GET",
+			target: "/v1/pay/init?TYPE=CHECK&IDN=123&TID=T1&MERCHANTID=M1&CHECKSUM=abc",
+			want: &Request{Type: "CHECK", IDN: "123", TID: "T1", MerchantID: "M1", Checksum: "abc", Values: url.Values{
+				"TYPE": {"CHECK"}, "IDN": {"123"}, "TID": {"T1"}, "MERCHANTID": {"M1"}, "CHECKSUM": {"abc"},
+			}},
+		},
+		{
+			name:        "form-encoded body",
+			method:      "POST",
+			target:      "/v1/pay/confirm",
+			body:        "TYPE=BILLING&IDN=123&TID=T1&AMOUNT=1050&CHECKSUM=abc",
+			contentType: "application/x-www-form-urlencoded",
+			want: &Request{Type: "BILLING", IDN: "123", TID: "T1", Amount: 1050, Checksum: "abc", Values: url.Values{
+				"TYPE": {"BILLING"}, "IDN": {"123"}, "TID": {"T1"}, "AMOUNT": {"1050"}, "CHECKSUM": {"abc"},
+			}},
+		},
+		{
+			name:    "bad amount",
+			method:  "GET",
+			target:  "/v1/pay/confirm?TYPE=BILLING&AMOUNT=1.5",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+			if c.contentType != "" {
+				r.Header.Set("Content-Type", c.contentType)
+			}
+
+			got, err := ParseRequest(r)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("ParseRequest() error = %v, wantErr: %v", err, c.wantErr)
+			}
+
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Fatal("unexpected request (-want +got): ", diff)
+			}
+		})
+	}
+}
+
+func TestPaymentTime(t *testing.T) {
+	cases := []struct {
+		name    string
+		date    string
+		want    time.Time
+		wantErr bool
+	}{
+		{"not provided", "", time.Time{}, false},
+		{"local time of ePay", "20200430233000", time.Date(2020, 4, 30, 20, 30, 0, 0, time.UTC), false},
+		{"bad date", "2020-04-30", time.Time{}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := &Request{Values: url.Values{"DATE": {c.date}}}
+			got, err := req.PaymentTime()
+			if (err != nil) != c.wantErr {
+				t.Fatalf("expected error: %v, got: %v", c.wantErr, err)
+			}
+			if !got.Equal(c.want) {
+				t.Errorf("expected: %v, got: %v", c.want, got)
+			}
+		})
+	}
+}
@@ -11,19 +11,19 @@ const (
 	billing checkType = "BILLING"
 
 	// StatusSuccess indicates the success of payment operation
-	StatusSuccess string = "00"
+	StatusSuccess = epay.StatusSuccess
 	// StatusSubscriberNotFound indicates that unknown subscriber was requested
-	StatusSubscriberNotFound string = "14"
+	StatusSubscriberNotFound = epay.StatusSubscriberNotFound
 	// StatusNoDuties indicates that subscriber has no dutiies
-	StatusNoDuties string = "62"
+	StatusNoDuties = epay.StatusNoDuties
 	// StatusTemporaryNotAvailable is indicating that service is temporary not available
-	StatusTemporaryNotAvailable string = "80"
+	StatusTemporaryNotAvailable = epay.StatusTemporaryNotAvailable
 	// StatusBadChecksum is indicating that received request is with bad checksum
-	StatusBadChecksum string = "93"
+	StatusBadChecksum = epay.StatusBadChecksum
 	// StatusAlreadyPaid is indicating the duty is alredy paid
-	StatusAlreadyPaid string = "94"
+	StatusAlreadyPaid = epay.StatusAlreadyPaid
 	// StatusCommonError indicates a common error
-	StatusCommonError string = "96"
+	StatusCommonError = epay.StatusCommonError
 
 	// EPAY payment source
 	EPAY epay.PaymentSource = "EPAY"
@@ -1,16 +1,24 @@
 package api
 
+import "github.com/clouway/go-epay/pkg/epay"
+
 var (
 	// ErrBadChecksum is indicating that received request is with bad checksum
-	ErrBadChecksum = &DutyResponse{Status: "93"}
+	ErrBadChecksum = &DutyResponse{Status: StatusBadChecksum}
 )
 
 // DutyResponse is a common response which is returned from the server.
 type DutyResponse struct {
-	Status    string `json:"STATUS,omitempty"`
-	IDN       string `json:"IDN,omitempty"`
-	ShortDesc string `json:"SHORTDESC,omitempty"`
-	LongDesc  string `json:"LONGDESC,omitempty"`
-	Amount    int    `json:"AMOUNT,omitempty"`
-	ValidTo   string `json:"VALIDTO,omitempty"`
+	Status    epay.Status `json:"STATUS,omitempty"`
+	IDN       string      `json:"IDN,omitempty"`
+	ShortDesc string      `json:"SHORTDESC,omitempty"`
+	LongDesc  string      `json:"LONGDESC,omitempty"`
+	Amount    int         `json:"AMOUNT,omitempty"`
+	ValidTo   string      `json:"VALIDTO,omitempty"`
+}
+
+// ErrorResponse is a response which is returned when request could not be processed.
+type ErrorResponse struct {
+	Status epay.Status `json:"STATUS"`
+	Error  string      `json:"ERROR,omitempty"`
 }
@@ -1,8 +1,29 @@
 package server
 
+import (
+	"context"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
 const (
 	// EnvironmentKey is representing the key of the environment.
 	EnvironmentKey key = iota
+	// TenantKey is representing the key of the resolved tenant name.
+	TenantKey
 )
 
 type key int
+
+// EnvironmentFromContext gets the environment which is associated with the provided
+// context. The returned bool is false when no environment is associated with it.
+func EnvironmentFromContext(ctx context.Context) (*epay.Environment, bool) {
+	env, ok := ctx.Value(EnvironmentKey).(*epay.Environment)
+	return env, ok && env != nil
+}
+
+// TenantFromContext gets the name of the tenant which is associated with the provided context.
+func TenantFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(TenantKey).(string)
+	return name
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/datastore"
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// EnvironmentMigration is the result of the migration of a single environment
+// from the legacy layout to the current one.
+type EnvironmentMigration struct {
+	// Name is the name of the environment.
+	Name string
+
+	// Legacy indicates whether the environment has properties of the legacy layout.
+	Legacy bool
+
+	// Conflicts are the properties which are present in both layouts with different
+	// values. The values of the current layout are kept.
+	Conflicts []string
+
+	// Dropped are the properties which are not part of the environment and are
+	// removed when the environment is migrated.
+	Dropped []string
+
+	// Migrated indicates whether the environment was rewritten in the current layout.
+	Migrated bool
+
+	// Err is the error which occurred during the migration of the environment.
+	Err error
+}
+
+// MigrateEnvironments rewrites the environments which are stored in the legacy layout
+// into the current layout. Environments are only inspected when dryRun is true.
+func MigrateEnvironments(ctx context.Context, client *datastore.Client, dryRun bool) ([]EnvironmentMigration, error) {
+	keys, err := client.GetAll(ctx, datastore.NewQuery(environmentKind).KeysOnly(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not list environments due: %v", err)
+	}
+
+	known, err := knownProperties()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]EnvironmentMigration, 0, len(keys))
+	for _, k := range keys {
+		m := EnvironmentMigration{Name: k.Name}
+
+		_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			var ps datastore.PropertyList
+			if err := tx.Get(k, &ps); err != nil {
+				return err
+			}
+
+			m = inspectEnvironment(k.Name, ps, known)
+			if !m.Legacy || dryRun {
+				return nil
+			}
+
+			e := &environmentEntity{}
+			if err := e.Load(ps); err != nil {
+				return err
+			}
+			if _, err := tx.Put(k, e); err != nil {
+				return err
+			}
+			m.Migrated = true
+			return nil
+		})
+		if err != nil {
+			m.Migrated = false
+			m.Err = err
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// inspectEnvironment reports the legacy, the conflicting and the unknown properties of the environment.
+func inspectEnvironment(name string, ps []datastore.Property, known map[string]bool) EnvironmentMigration {
+	m := EnvironmentMigration{Name: name}
+
+	values := make(map[string]interface{}, len(ps))
+	for _, p := range ps {
+		values[p.Name] = p.Value
+	}
+
+	for _, p := range ps {
+		canonical := CanonicalPropertyName(p.Name)
+		if canonical != p.Name {
+			m.Legacy = true
+			if v, ok := values[canonical]; ok && v != p.Value {
+				m.Conflicts = append(m.Conflicts, p.Name)
+			}
+			continue
+		}
+		if !known[p.Name] {
+			m.Dropped = append(m.Dropped, p.Name)
+		}
+	}
+	sort.Strings(m.Conflicts)
+	sort.Strings(m.Dropped)
+	return m
+}
+
+// knownProperties gets the names of the properties of the current layout.
+func knownProperties() (map[string]bool, error) {
+	ps, err := (&environmentEntity{CircuitBreaker: &epay.CircuitBreaker{}}).Save()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(ps))
+	for _, p := range ps {
+		known[p.Name] = true
+	}
+	return known, nil
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadLegacyEnvironment(t *testing.T) {
+	ps := []datastore.Property{
+		{Name: "billingKey", Value: "{}"},
+		{Name: "billingURL", Value: "https://cloud.telcong.com"},
+		{Name: "epaySecret", Value: "legacy"},
+		{Name: "EpaySecret", Value: "current"},
+		{Name: "merchantId", Value: "123"},
+		{Name: "metadata", Value: `{"apiKey":"key"}`},
+	}
+
+	e := &environmentEntity{}
+	if err := e.Load(ps); err != nil {
+		t.Fatalf("unable to load environment due: %v", err)
+	}
+
+	want := &environmentEntity{
+		BillingKey: "{}",
+		BillingURL: "https://cloud.telcong.com",
+		EpaySecret: "current",
+		MerchantID: "123",
+		Metadata:   map[string]string{"apiKey": "key"},
+	}
+	if diff := cmp.Diff(want, e); diff != "" {
+		t.Errorf("unexpected environment (-want +got): %s", diff)
+	}
+}
+
+func TestInspectEnvironment(t *testing.T) {
+	known, err := knownProperties()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		ps   []datastore.Property
+		want EnvironmentMigration
+	}{
+		{
+			name: "current layout",
+			ps: []datastore.Property{
+				{Name: "EpaySecret", Value: "secret"},
+				{Name: "metadata", Value: "{}"},
+			},
+			want: EnvironmentMigration{Name: "current layout"},
+		},
+		{
+			name: "legacy layout",
+			ps: []datastore.Property{
+				{Name: "epaySecret", Value: "legacy"},
+				{Name: "EpaySecret", Value: "current"},
+				{Name: "merchantId", Value: "123"},
+				{Name: "comment", Value: "test"},
+			},
+			want: EnvironmentMigration{
+				Name:      "legacy layout",
+				Legacy:    true,
+				Conflicts: []string{"epaySecret"},
+				Dropped:   []string{"comment"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		got := inspectEnvironment(c.name, c.ps, known)
+		if diff := cmp.Diff(c.want, got); diff != "" {
+			t.Errorf("%s: unexpected migration (-want +got): %s", c.name, diff)
+		}
+	}
+}
+
+func TestMergeStoredProperties(t *testing.T) {
+	stored := []datastore.Property{
+		{Name: "Type", Value: "ucrm"},
+		{Name: "EpaySecret", Value: "old"},
+		{Name: "metadata", Value: `{"apiKey":"old"}`},
+		{Name: "circuitBreaker", Value: `{"disabled":true}`},
+		{Name: "notes", Value: "kept"},
+	}
+
+	e := &environmentEntity{}
+	if err := e.Load(stored); err != nil {
+		t.Fatalf("unable to load environment due: %v", err)
+	}
+	e.EpaySecret = "new"
+	e.Metadata = map[string]string{"apiKey": "new"}
+	e.CircuitBreaker = nil
+
+	saved, err := e.Save()
+	if err != nil {
+		t.Fatalf("unable to save environment due: %v", err)
+	}
+
+	known, err := knownProperties()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]interface{})
+	for _, p := range mergeProperties(stored, saved, known) {
+		got[p.Name] = p.Value
+	}
+
+	want := map[string]interface{}{
+		"Type":           "ucrm",
+		"EpaySecret":     "new",
+		"metadata":       `{"apiKey":"new"}`,
+		"circuitBreaker": nil,
+		"notes":          "kept",
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("expected: %s = %v, got: %v", name, value, got[name])
+		}
+	}
+}
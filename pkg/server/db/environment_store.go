@@ -4,14 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 
 	"cloud.google.com/go/datastore"
 	"github.com/clouway/go-epay/pkg/epay"
 )
 
+const environmentKind = "Environment"
+
 // NewEnvironmentStore creates a new environment store that is using datastore as a backend layer.
-func NewEnvironmentStore(client *datastore.Client) epay.EnvironmentStore {
+func NewEnvironmentStore(client *datastore.Client) epay.EnvironmentRegistry {
 	return &store{client}
 }
 
@@ -20,26 +21,138 @@ type store struct {
 }
 
 func (s *store) Get(ctx context.Context, name string) (*epay.Environment, error) {
-	// A default name should be used
-	if strings.Contains(name, "appspot") || name == "" {
-		name = "default"
-	}
-
-	k := datastore.NameKey("Environment", name, nil)
+	k := datastore.NameKey(environmentKind, name, nil)
 
 	e := &environmentEntity{}
 	if err := s.c.Get(ctx, k, e); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, epay.ErrEnvironmentNotFound
+		}
 		return nil, fmt.Errorf("could not load the environment '%s' due: %v", name, err)
 	}
+	return e.environment(name)
+}
 
-	return &epay.Environment{
-		BillingJWTKey: e.BillingKey,
-		BillingKey:    e.BillingKey,
-		BillingURL:    e.BillingURL,
-		EpaySecret:    e.EpaySecret,
-		MerchantID:    e.MerchantID,
-		Metadata:      e.Metadata,
-	}, nil
+func (s *store) List(ctx context.Context) ([]string, error) {
+	keys, err := s.c.GetAll(ctx, datastore.NewQuery(environmentKind).KeysOnly(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not list environments due: %v", err)
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		names = append(names, k.Name)
+	}
+	return names, nil
+}
+
+// Put stores the environment by merging it into the stored entity, so the Type and the properties
+// which are not part of the environment are kept.
+func (s *store) Put(ctx context.Context, name string, env *epay.Environment) error {
+	k := datastore.NameKey(environmentKind, name, nil)
+
+	_, err := s.c.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var stored datastore.PropertyList
+		if err := tx.Get(k, &stored); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		return putEnvironment(tx, k, stored, env)
+	})
+	if err != nil {
+		return fmt.Errorf("could not store the environment '%s' due: %v", name, err)
+	}
+	return nil
+}
+
+// UpdateEnvironment gets the environment with the provided name and stores the changes of update
+// in a single transaction, so changes which are made concurrently are not overwritten. The environment
+// is not stored when update reports that it's not changed. Note that update could be called more than
+// once when the transaction is retried.
+func UpdateEnvironment(ctx context.Context, client *datastore.Client, name string, update func(env *epay.Environment) (bool, error)) error {
+	k := datastore.NameKey(environmentKind, name, nil)
+
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var stored datastore.PropertyList
+		if err := tx.Get(k, &stored); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return epay.ErrEnvironmentNotFound
+			}
+			return err
+		}
+
+		e := &environmentEntity{}
+		if err := e.Load(stored); err != nil {
+			return err
+		}
+		env, err := e.environment(name)
+		if err != nil {
+			return err
+		}
+
+		changed, err := update(env)
+		if err != nil || !changed {
+			return err
+		}
+		return putEnvironment(tx, k, stored, env)
+	})
+	if err == epay.ErrEnvironmentNotFound {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("could not update the environment '%s' due: %v", name, err)
+	}
+	return nil
+}
+
+// putEnvironment stores the environment within the transaction by merging it into the stored
+// properties, so the Type and the properties which are not part of the environment are kept.
+func putEnvironment(tx *datastore.Transaction, k *datastore.Key, stored []datastore.Property, env *epay.Environment) error {
+	stored, _ = canonicalProperties(stored)
+
+	billingKey := env.BillingKey
+	if billingKey == "" {
+		billingKey = env.BillingJWTKey
+	}
+
+	e := &environmentEntity{}
+	if err := e.Load(stored); err != nil {
+		return err
+	}
+	e.BillingKey = billingKey
+	e.BillingURL = env.BillingURL
+	e.EpaySecret = env.EpaySecret
+	e.MerchantID = env.MerchantID
+	e.NameMasking = string(env.NameMasking)
+	e.IDNMasking = env.IDNMasking
+	e.Metadata = env.Metadata
+	e.IDNRules = env.IDNRules
+	e.EpaySecrets = env.EpaySecrets
+	e.CircuitBreaker = env.CircuitBreaker
+
+	saved, err := e.Save()
+	if err != nil {
+		return err
+	}
+	known, err := knownProperties()
+	if err != nil {
+		return err
+	}
+	ps := datastore.PropertyList(mergeProperties(stored, saved, known))
+	_, err = tx.Put(k, &ps)
+	return err
+}
+
+// mergeProperties merges the saved properties of the entity into the stored ones. The stored
+// properties which are not known to the entity are kept as they are, while the known ones are
+// replaced, so an optional property such as circuitBreaker is removed when it's not saved.
+func mergeProperties(stored, saved []datastore.Property, known map[string]bool) []datastore.Property {
+	result := append([]datastore.Property{}, saved...)
+	for _, p := range stored {
+		if !known[p.Name] {
+			result = append(result, p)
+		}
+	}
+	return result
 }
 
 type environmentEntity struct {
@@ -49,20 +162,70 @@ type environmentEntity struct {
 	BillingURL string
 	EpaySecret string
 	MerchantID string
-	Metadata   map[string]string `datastore:"-"`
+	// NameMasking is one of none, full, initials or firstname
+	NameMasking string
+	IDNMasking  bool
+	Metadata    map[string]string `datastore:"-"`
+	IDNRules    []epay.IDNRule    `datastore:"-"`
+	EpaySecrets []epay.Secret     `datastore:"-"`
+
+	CircuitBreaker *epay.CircuitBreaker `datastore:"-"`
+}
+
+// environment gets the environment of the entity with the provided name.
+func (e *environmentEntity) environment(name string) (*epay.Environment, error) {
+	for i, r := range e.IDNRules {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("environment '%s' has invalid IDN rule #%d: %v", name, i, err)
+		}
+	}
+
+	return &epay.Environment{
+		BillingJWTKey: e.BillingKey,
+		BillingKey:    e.BillingKey,
+		BillingURL:    e.BillingURL,
+		EpaySecret:    e.EpaySecret,
+		EpaySecrets:   e.EpaySecrets,
+		MerchantID:    e.MerchantID,
+		Metadata:      e.Metadata,
+		NameMasking:   epay.NameMasking(e.NameMasking),
+		IDNMasking:    e.IDNMasking,
+		IDNRules:      e.IDNRules,
+
+		CircuitBreaker: e.CircuitBreaker,
+	}, nil
 }
 
 func (e *environmentEntity) Load(ps []datastore.Property) error {
+	ps, _ = canonicalProperties(ps)
+
 	// Stored fields could not be loaded when struct is not having the same field for safety. This check
 	// ensures that entity will be loaded with it's metadata field.
-	if err := datastore.LoadStruct(e, ps); err != nil && err != err.(*datastore.ErrFieldMismatch) {
-		return err
+	if err := datastore.LoadStruct(e, ps); err != nil {
+		if _, ok := err.(*datastore.ErrFieldMismatch); !ok {
+			return err
+		}
 	}
 
 	for _, p := range ps {
 		if p.Name == "metadata" {
 			json.Unmarshal([]byte(p.Value.(string)), &e.Metadata)
 		}
+		if p.Name == "idnRules" {
+			if err := json.Unmarshal([]byte(p.Value.(string)), &e.IDNRules); err != nil {
+				return fmt.Errorf("could not decode idnRules due: %v", err)
+			}
+		}
+		if p.Name == "epaySecrets" {
+			if err := json.Unmarshal([]byte(p.Value.(string)), &e.EpaySecrets); err != nil {
+				return fmt.Errorf("could not decode epaySecrets due: %v", err)
+			}
+		}
+		if p.Name == "circuitBreaker" {
+			if err := json.Unmarshal([]byte(p.Value.(string)), &e.CircuitBreaker); err != nil {
+				return fmt.Errorf("could not decode circuitBreaker due: %v", err)
+			}
+		}
 	}
 	return nil
 }
@@ -82,5 +245,80 @@ func (e *environmentEntity) Save() ([]datastore.Property, error) {
 		NoIndex: true,
 	})
 
+	idnRules, err := json.Marshal(e.IDNRules)
+	if err != nil {
+		return nil, err
+	}
+	props = append(props, datastore.Property{
+		Name:    "idnRules",
+		Value:   string(idnRules),
+		NoIndex: true,
+	})
+
+	epaySecrets, err := json.Marshal(e.EpaySecrets)
+	if err != nil {
+		return nil, err
+	}
+	props = append(props, datastore.Property{
+		Name:    "epaySecrets",
+		Value:   string(epaySecrets),
+		NoIndex: true,
+	})
+
+	if e.CircuitBreaker != nil {
+		circuitBreaker, err := json.Marshal(e.CircuitBreaker)
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, datastore.Property{
+			Name:    "circuitBreaker",
+			Value:   string(circuitBreaker),
+			NoIndex: true,
+		})
+	}
+
 	return props, nil
 }
+
+// legacyProperties maps the property names of the legacy layout of the environment, which
+// was written by pkg/gae/config, to the property names of the current layout.
+var legacyProperties = map[string]string{
+	"billingKey": "BillingKey",
+	"billingURL": "BillingURL",
+	"epaySecret": "EpaySecret",
+	"merchantId": "MerchantID",
+}
+
+// CanonicalPropertyName gets the name of the property in the current layout of the
+// environment for the provided property name of either layout.
+func CanonicalPropertyName(name string) string {
+	if n, ok := legacyProperties[name]; ok {
+		return n
+	}
+	return name
+}
+
+// canonicalProperties renames the properties of the legacy layout to their names in the
+// current layout. Properties of the current layout take precedence when both are present.
+// It's reporting whether any legacy property was found.
+func canonicalProperties(ps []datastore.Property) ([]datastore.Property, bool) {
+	present := make(map[string]bool, len(ps))
+	for _, p := range ps {
+		present[p.Name] = true
+	}
+
+	legacy := false
+	result := make([]datastore.Property, 0, len(ps))
+	for _, p := range ps {
+		name := CanonicalPropertyName(p.Name)
+		if name != p.Name {
+			legacy = true
+			if present[name] {
+				continue
+			}
+			p.Name = name
+		}
+		result = append(result, p)
+	}
+	return result, legacy
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/clouway/go-epay/pkg/server"
+)
+
+const replayKind = "ReplayedRequest"
+
+// NewReplayStore creates a new replay store that is using datastore as a backend layer, so
+// replayed requests are recognized across all instances.
+func NewReplayStore(client *datastore.Client) server.ReplayStore {
+	return &replayStore{client}
+}
+
+type replayStore struct {
+	c *datastore.Client
+}
+
+func (s *replayStore) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, *server.ReplayResponse, error) {
+	k := datastore.NameKey(replayKind, key, nil)
+
+	var (
+		reserved bool
+		resp     *server.ReplayResponse
+	)
+	_, err := s.c.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		reserved, resp = false, nil
+
+		e := &replayEntity{}
+		err := tx.Get(k, e)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		now := time.Now()
+		if err == nil && now.Before(e.ExpiresAt) {
+			if e.Completed {
+				resp = &server.ReplayResponse{StatusCode: e.StatusCode, ContentType: e.ContentType, Body: e.Body}
+			}
+			return nil
+		}
+
+		reserved = true
+		_, err = tx.Put(k, &replayEntity{ExpiresAt: now.Add(ttl)})
+		return err
+	})
+	if err != nil {
+		return false, nil, fmt.Errorf("could not reserve request due: %v", err)
+	}
+	return reserved, resp, nil
+}
+
+func (s *replayStore) Complete(ctx context.Context, key string, resp server.ReplayResponse, ttl time.Duration) error {
+	k := datastore.NameKey(replayKind, key, nil)
+	e := &replayEntity{
+		Completed:   true,
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.ContentType,
+		Body:        resp.Body,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	if _, err := s.c.Put(ctx, k, e); err != nil {
+		return fmt.Errorf("could not store response due: %v", err)
+	}
+	return nil
+}
+
+func (s *replayStore) Release(ctx context.Context, key string) error {
+	k := datastore.NameKey(replayKind, key, nil)
+	if err := s.c.Delete(ctx, k); err != nil {
+		return fmt.Errorf("could not release request due: %v", err)
+	}
+	return nil
+}
+
+type replayEntity struct {
+	Completed   bool      `datastore:"completed,noindex"`
+	StatusCode  int       `datastore:"statusCode,noindex"`
+	ContentType string    `datastore:"contentType,noindex"`
+	Body        []byte    `datastore:"body,noindex"`
+	ExpiresAt   time.Time `datastore:"expiresAt"`
+}
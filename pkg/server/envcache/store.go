@@ -0,0 +1,249 @@
+// Package envcache provides a caching decorator of epay.EnvironmentStore.
+//
+// The cache is kept in the memory of each instance, so invalidation affects only the instance
+// which received it, while the other instances are using their cached environments until they
+// expire.
+package envcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// Options are the options of the cache.
+type Options struct {
+	// TTL is the time for which loaded environments are cached.
+	TTL time.Duration
+
+	// NegativeTTL is the time for which unknown environments are cached. Unknown
+	// environments are not cached when it's zero.
+	NegativeTTL time.Duration
+
+	// MaxEntries is the number of cached environments above which the expired ones are
+	// removed and then the others are evicted. DefaultMaxEntries is used when it's zero.
+	MaxEntries int
+}
+
+// DefaultMaxEntries is the default number of cached environments.
+const DefaultMaxEntries = 10000
+
+// loadTimeout is the timeout of loading of an environment from the underlying store.
+const loadTimeout = 30 * time.Second
+
+// Stats are the statistics of the cache usage.
+type Stats struct {
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negativeHits"`
+	Misses       uint64 `json:"misses"`
+	Errors       uint64 `json:"errors"`
+	Size         int    `json:"size"`
+}
+
+// Store is an epay.EnvironmentStore which caches the environments of the
+// underlying store. Concurrent loads of the same environment are de-duplicated.
+type Store struct {
+	store epay.EnvironmentStore
+	opts  Options
+
+	mu         sync.Mutex
+	entries    map[string]entry
+	calls      map[string]*call
+	generation uint64
+
+	hits, negativeHits, misses, errors uint64
+}
+
+type entry struct {
+	env       *epay.Environment
+	expiresAt time.Time
+}
+
+// call is an in-flight or completed load of environment.
+type call struct {
+	wg  sync.WaitGroup
+	env *epay.Environment
+	err error
+}
+
+// New creates a new caching store which decorates the provided store.
+func New(store epay.EnvironmentStore, opts Options) *Store {
+	return &Store{
+		store:   store,
+		opts:    opts,
+		entries: make(map[string]entry),
+		calls:   make(map[string]*call),
+	}
+}
+
+// Get gets the environment from the cache or loads it from the underlying store
+// when it's not cached or it's expired.
+func (s *Store) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	s.mu.Lock()
+	if e, ok := s.entries[name]; ok && time.Now().Before(e.expiresAt) {
+		s.mu.Unlock()
+		if e.env == nil {
+			atomic.AddUint64(&s.negativeHits, 1)
+			return nil, epay.ErrEnvironmentNotFound
+		}
+		atomic.AddUint64(&s.hits, 1)
+		return copyOf(e.env), nil
+	}
+	atomic.AddUint64(&s.misses, 1)
+
+	if c, ok := s.calls[name]; ok {
+		s.mu.Unlock()
+		c.wg.Wait()
+		return copyOf(c.env), c.err
+	}
+
+	c := &call{}
+	c.wg.Add(1)
+	s.calls[name] = c
+	generation := s.generation
+	s.mu.Unlock()
+
+	// the load is shared by all callers, so it's not cancelled together with the first one of them
+	loadCtx, cancel := context.WithTimeout(detachedContext{ctx}, loadTimeout)
+	c.env, c.err = s.store.Get(loadCtx, name)
+	cancel()
+	c.wg.Done()
+
+	s.mu.Lock()
+	delete(s.calls, name)
+	// environments which were invalidated during the load are not cached
+	if generation == s.generation {
+		switch {
+		case c.err == nil:
+			s.put(name, entry{env: c.env, expiresAt: time.Now().Add(s.opts.TTL)})
+		case errors.Is(c.err, epay.ErrEnvironmentNotFound) && s.opts.NegativeTTL > 0:
+			s.put(name, entry{expiresAt: time.Now().Add(s.opts.NegativeTTL)})
+		}
+	}
+	s.mu.Unlock()
+
+	if c.err != nil && !errors.Is(c.err, epay.ErrEnvironmentNotFound) {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	return copyOf(c.env), c.err
+}
+
+// put caches the entry with the provided name. The expired entries are removed when the cache
+// is full and then other entries are evicted, as unknown names are cached too and they are
+// provided by the clients. It should be called with the lock held.
+func (s *Store) put(name string, e entry) {
+	max := s.opts.MaxEntries
+	if max <= 0 {
+		max = DefaultMaxEntries
+	}
+	if _, ok := s.entries[name]; !ok && len(s.entries) >= max {
+		now := time.Now()
+		for k, e := range s.entries {
+			if !now.Before(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		for k := range s.entries {
+			if len(s.entries) < max {
+				break
+			}
+			delete(s.entries, k)
+		}
+	}
+	s.entries[name] = e
+}
+
+// Invalidate removes the environment with the provided name from the cache of this instance.
+func (s *Store) Invalidate(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, name)
+	s.generation++
+}
+
+// InvalidateAll removes all environments from the cache of this instance.
+func (s *Store) InvalidateAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = make(map[string]entry)
+	s.generation++
+}
+
+// Stats gets the statistics of the cache usage.
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	size := len(s.entries)
+	s.mu.Unlock()
+
+	return Stats{
+		Hits:         atomic.LoadUint64(&s.hits),
+		NegativeHits: atomic.LoadUint64(&s.negativeHits),
+		Misses:       atomic.LoadUint64(&s.misses),
+		Errors:       atomic.LoadUint64(&s.errors),
+		Size:         size,
+	}
+}
+
+// copyOf returns a deep copy of the environment, so callers could not modify the cached one.
+func copyOf(env *epay.Environment) *epay.Environment {
+	if env == nil {
+		return nil
+	}
+	c := *env
+
+	if env.Metadata != nil {
+		c.Metadata = make(map[string]string, len(env.Metadata))
+		for k, v := range env.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	if env.EpaySecrets != nil {
+		c.EpaySecrets = append([]epay.Secret{}, env.EpaySecrets...)
+	}
+	if env.IDNRules != nil {
+		c.IDNRules = make([]epay.IDNRule, len(env.IDNRules))
+		for i, r := range env.IDNRules {
+			c.IDNRules[i] = copyOfRule(r)
+		}
+	}
+	if env.CircuitBreaker != nil {
+		cb := *env.CircuitBreaker
+		c.CircuitBreaker = &cb
+	}
+	return &c
+}
+
+// copyOfRule returns a deep copy of the IDN rule.
+func copyOfRule(r epay.IDNRule) epay.IDNRule {
+	c := r
+	if r.Exact != nil {
+		c.Exact = append([]string{}, r.Exact...)
+	}
+	if r.Prefix != nil {
+		c.Prefix = append([]string{}, r.Prefix...)
+	}
+	if r.Operations != nil {
+		c.Operations = append([]epay.Operation{}, r.Operations...)
+	}
+	if r.Range != nil {
+		rng := *r.Range
+		c.Range = &rng
+	}
+	return c
+}
+
+// detachedContext is a context which is keeping the values of it's parent, but is not
+// cancelled together with it.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
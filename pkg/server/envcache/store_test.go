@@ -0,0 +1,186 @@
+package envcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetIsCached(t *testing.T) {
+	fs := &fakeStore{envs: map[string]*epay.Environment{"default": {MerchantID: "M1"}}}
+	s := New(fs, Options{TTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		env, err := s.Get(context.Background(), "default")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if env.MerchantID != "M1" {
+			t.Errorf("expected environment of M1, but got: %v", env)
+		}
+	}
+
+	if got := fs.loads(); got != 1 {
+		t.Errorf("expected environment to be loaded once, but was loaded %d times", got)
+	}
+	if stats := s.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("expected 2 hits and 1 miss, but got: %+v", stats)
+	}
+}
+
+func TestUnknownEnvironmentIsCached(t *testing.T) {
+	fs := &fakeStore{}
+	s := New(fs, Options{TTL: time.Minute, NegativeTTL: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Get(context.Background(), "unknown"); err != epay.ErrEnvironmentNotFound {
+			t.Fatalf("expected environment not found, but got: %v", err)
+		}
+	}
+
+	if got := fs.loads(); got != 1 {
+		t.Errorf("expected environment to be loaded once, but was loaded %d times", got)
+	}
+	if stats := s.Stats(); stats.NegativeHits != 1 {
+		t.Errorf("expected 1 negative hit, but got: %+v", stats)
+	}
+}
+
+func TestErrorsAreNotCached(t *testing.T) {
+	fs := &fakeStore{err: errors.New("datastore is not available")}
+	s := New(fs, Options{TTL: time.Minute, NegativeTTL: time.Minute})
+
+	s.Get(context.Background(), "default")
+	s.Get(context.Background(), "default")
+
+	if got := fs.loads(); got != 2 {
+		t.Errorf("expected environment to be loaded twice, but was loaded %d times", got)
+	}
+	if stats := s.Stats(); stats.Errors != 2 {
+		t.Errorf("expected 2 errors, but got: %+v", stats)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	fs := &fakeStore{envs: map[string]*epay.Environment{"default": {MerchantID: "M1"}}}
+	s := New(fs, Options{TTL: time.Minute})
+
+	s.Get(context.Background(), "default")
+	s.Invalidate("default")
+	s.Get(context.Background(), "default")
+	s.InvalidateAll()
+	s.Get(context.Background(), "default")
+
+	if got := fs.loads(); got != 3 {
+		t.Errorf("expected environment to be loaded 3 times, but was loaded %d times", got)
+	}
+}
+
+func TestCachedEnvironmentIsNotModified(t *testing.T) {
+	newEnv := func() *epay.Environment {
+		return &epay.Environment{
+			Metadata:       map[string]string{"apiKey": "key"},
+			EpaySecrets:    []epay.Secret{{ID: "2020-01", Value: "secret"}},
+			IDNRules:       []epay.IDNRule{{Exact: []string{"1111111111"}, Range: &epay.IDNRange{From: 1, To: 2}}},
+			CircuitBreaker: &epay.CircuitBreaker{FailureThreshold: 5},
+		}
+	}
+	fs := &fakeStore{envs: map[string]*epay.Environment{"default": newEnv()}}
+	s := New(fs, Options{TTL: time.Minute})
+
+	env, _ := s.Get(context.Background(), "default")
+	env.Metadata["apiKey"] = "changed"
+	env.EpaySecrets[0].Value = "changed"
+	env.IDNRules[0].Exact[0] = "changed"
+	env.IDNRules[0].Range.To = 10
+	env.CircuitBreaker.FailureThreshold = 1
+
+	got, _ := s.Get(context.Background(), "default")
+	if diff := cmp.Diff(newEnv(), got); diff != "" {
+		t.Errorf("cached environment was modified (-want +got): %s", diff)
+	}
+}
+
+func TestCacheIsBounded(t *testing.T) {
+	fs := &fakeStore{envs: map[string]*epay.Environment{"default": {MerchantID: "M1"}}}
+	s := New(fs, Options{TTL: time.Minute, NegativeTTL: time.Minute, MaxEntries: 2})
+
+	for _, name := range []string{"default", "unknown1", "unknown2", "unknown3"} {
+		s.Get(context.Background(), name)
+	}
+
+	if stats := s.Stats(); stats.Size != 2 {
+		t.Errorf("expected 2 cached environments, but got: %+v", stats)
+	}
+}
+
+func TestLoadIsNotCancelledByCaller(t *testing.T) {
+	fs := &fakeStore{envs: map[string]*epay.Environment{"default": {MerchantID: "M1"}}}
+	s := New(fs, Options{TTL: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.Get(ctx, "default"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConcurrentLoadsAreDeduplicated(t *testing.T) {
+	release := make(chan struct{})
+	fs := &fakeStore{envs: map[string]*epay.Environment{"default": {MerchantID: "M1"}}, wait: release}
+	s := New(fs, Options{TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Get(context.Background(), "default"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := fs.loads(); got != 1 {
+		t.Errorf("expected environment to be loaded once, but was loaded %d times", got)
+	}
+}
+
+type fakeStore struct {
+	envs  map[string]*epay.Environment
+	err   error
+	wait  chan struct{}
+	count int64
+}
+
+func (f *fakeStore) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	atomic.AddInt64(&f.count, 1)
+	if f.wait != nil {
+		<-f.wait
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	env, ok := f.envs[name]
+	if !ok {
+		return nil, epay.ErrEnvironmentNotFound
+	}
+	return env, nil
+}
+
+func (f *fakeStore) loads() int64 {
+	return atomic.LoadInt64(&f.count)
+}
@@ -0,0 +1,72 @@
+// Package envstore provides implementations of epay.EnvironmentStore which are not
+// depending on Google Cloud, so goepay could be run on any environment.
+package envstore
+
+import (
+	"fmt"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// Document is the serialized form of the environment which is used by the file and
+// the SQL stores and the admin API. It's using the property names of the datastore entity. The
+// idnRules are kept when they are empty, as the default IDN rules are used when they are missing.
+type Document struct {
+	BillingKey  string            `json:"billingKey,omitempty"`
+	BillingURL  string            `json:"billingURL,omitempty"`
+	EpaySecret  string            `json:"epaySecret,omitempty"`
+	EpaySecrets []epay.Secret     `json:"epaySecrets,omitempty"`
+	MerchantID  string            `json:"merchantId,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	NameMasking string            `json:"nameMasking,omitempty"`
+	IDNMasking  bool              `json:"idnMasking,omitempty"`
+	IDNRules    []epay.IDNRule    `json:"idnRules"`
+
+	CircuitBreaker *epay.CircuitBreaker `json:"circuitBreaker,omitempty"`
+}
+
+// Environment converts the document to the environment with the provided name.
+func (d *Document) Environment(name string) (*epay.Environment, error) {
+	for i, r := range d.IDNRules {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("environment '%s' has invalid IDN rule #%d: %v", name, i, err)
+		}
+	}
+
+	return &epay.Environment{
+		BillingJWTKey: d.BillingKey,
+		BillingKey:    d.BillingKey,
+		BillingURL:    d.BillingURL,
+		EpaySecret:    d.EpaySecret,
+		EpaySecrets:   d.EpaySecrets,
+		MerchantID:    d.MerchantID,
+		Metadata:      d.Metadata,
+		NameMasking:   epay.NameMasking(d.NameMasking),
+		IDNMasking:    d.IDNMasking,
+		IDNRules:      d.IDNRules,
+
+		CircuitBreaker: d.CircuitBreaker,
+	}, nil
+}
+
+// NewDocument creates a new document from the provided environment.
+func NewDocument(env *epay.Environment) *Document {
+	billingKey := env.BillingKey
+	if billingKey == "" {
+		billingKey = env.BillingJWTKey
+	}
+
+	return &Document{
+		BillingKey:  billingKey,
+		BillingURL:  env.BillingURL,
+		EpaySecret:  env.EpaySecret,
+		EpaySecrets: env.EpaySecrets,
+		MerchantID:  env.MerchantID,
+		Metadata:    env.Metadata,
+		NameMasking: string(env.NameMasking),
+		IDNMasking:  env.IDNMasking,
+		IDNRules:    env.IDNRules,
+
+		CircuitBreaker: env.CircuitBreaker,
+	}
+}
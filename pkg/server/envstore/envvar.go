@@ -0,0 +1,67 @@
+package envstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// NewEnvVarStore creates a new store of a single environment which is configured through
+// environment variables with the provided prefix, e.g:
+//
+//	EPAY_SECRET - the secret provided by ePay
+//	EPAY_MERCHANT_ID - the id of the merchant
+//	EPAY_BILLING_URL - the url of the billing
+//	EPAY_BILLING_KEY_FILE - the path to the billing JSON key
+//	EPAY_METADATA - JSON object of the metadata attributes
+//	EPAY_NAME_MASKING - the masking of customer names
+//
+// The environment is returned for every name, so it's suitable only for single-tenant setups.
+func NewEnvVarStore(prefix string) (epay.EnvironmentStore, error) {
+	d := &Document{
+		EpaySecret:  os.Getenv(prefix + "SECRET"),
+		MerchantID:  os.Getenv(prefix + "MERCHANT_ID"),
+		BillingURL:  os.Getenv(prefix + "BILLING_URL"),
+		NameMasking: os.Getenv(prefix + "NAME_MASKING"),
+		IDNMasking:  os.Getenv(prefix+"IDN_MASKING") == "true",
+	}
+
+	if file := os.Getenv(prefix + "BILLING_KEY_FILE"); file != "" {
+		key, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %sBILLING_KEY_FILE due: %v", prefix, err)
+		}
+		d.BillingKey = string(key)
+	}
+
+	if metadata := os.Getenv(prefix + "METADATA"); metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &d.Metadata); err != nil {
+			return nil, fmt.Errorf("%sMETADATA is not a JSON object: %v", prefix, err)
+		}
+	}
+
+	if idnRules := os.Getenv(prefix + "IDN_RULES"); idnRules != "" {
+		if err := json.Unmarshal([]byte(idnRules), &d.IDNRules); err != nil {
+			return nil, fmt.Errorf("%sIDN_RULES is not a JSON list: %v", prefix, err)
+		}
+	}
+
+	env, err := d.Environment("env")
+	if err != nil {
+		return nil, err
+	}
+	return &envVarStore{env}, nil
+}
+
+type envVarStore struct {
+	env *epay.Environment
+}
+
+func (s *envVarStore) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	c := *s.env
+	return &c, nil
+}
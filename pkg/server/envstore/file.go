@@ -0,0 +1,157 @@
+package envstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// FileStore is an epay.EnvironmentStore which loads the environments from YAML
+// or JSON file, where each environment is keyed by it's name, e.g
+//
+//	default:
+//	  billingURL: https://cloud.telcong.com
+//	  epaySecret: mysecret
+//	  merchantId: "123"
+type FileStore struct {
+	path string
+
+	mu      sync.RWMutex
+	envs    map[string]*epay.Environment
+	modTime time.Time
+}
+
+// NewFileStore creates a new store which loads the environments from the provided file. Files
+// with .json extension are decoded as JSON and all others as YAML.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get gets the environment with the provided name.
+func (s *FileStore) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	env, ok := s.envs[name]
+	if !ok {
+		return nil, epay.ErrEnvironmentNotFound
+	}
+	c := *env
+	return &c, nil
+}
+
+// Reload reloads the environments from the file. The previously loaded environments
+// are kept when the file could not be loaded.
+func (s *FileStore) Reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read environments file due: %v", err)
+	}
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read environments file due: %v", err)
+	}
+
+	docs := make(map[string]*Document)
+	if filepath.Ext(s.path) == ".json" {
+		err = json.Unmarshal(content, &docs)
+	} else {
+		err = unmarshalYAML(content, &docs)
+	}
+	if err != nil {
+		return fmt.Errorf("could not decode environments file '%s' due: %v", s.path, err)
+	}
+
+	envs := make(map[string]*epay.Environment, len(docs))
+	for name, d := range docs {
+		env, err := d.Environment(name)
+		if err != nil {
+			return err
+		}
+		envs[name] = env
+	}
+
+	s.mu.Lock()
+	s.envs = envs
+	s.modTime = info.ModTime()
+	s.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the environments each time the file is modified. The file is checked
+// on every interval until the provided context is done.
+func (s *FileStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				log.Warnf("could not check environments file due: %v", err)
+				continue
+			}
+
+			s.mu.RLock()
+			modified := !info.ModTime().Equal(s.modTime)
+			s.mu.RUnlock()
+			if !modified {
+				continue
+			}
+
+			if err := s.Reload(); err != nil {
+				log.Errorf("could not reload environments due: %v", err)
+				continue
+			}
+			log.Printf("environments were reloaded from '%s'", s.path)
+		}
+	}
+}
+
+// unmarshalYAML decodes YAML content by converting it to JSON first, so the JSON
+// names of the fields are used for both formats.
+func unmarshalYAML(content []byte, v interface{}) error {
+	var raw interface{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return err
+	}
+	j, err := json.Marshal(jsonCompatible(raw))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
+
+// jsonCompatible converts the maps decoded from YAML to maps with string keys.
+func jsonCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = jsonCompatible(v)
+		}
+		return m
+	case []interface{}:
+		for i, v := range t {
+			t[i] = jsonCompatible(v)
+		}
+	}
+	return v
+}
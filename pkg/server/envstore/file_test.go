@@ -0,0 +1,121 @@
+package envstore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFileStore(t *testing.T) {
+	cases := []struct {
+		file    string
+		content string
+	}{
+		{"envs.yaml", `
+default:
+  billingURL: http://billing
+  epaySecret: mysecret
+  merchantId: "123"
+  metadata:
+    apiKey: key
+  nameMasking: initials
+  idnRules:
+    - action: ignore
+      exact: ["1111"]
+`},
+		{"envs.json", `{"default": {
+  "billingURL": "http://billing",
+  "epaySecret": "mysecret",
+  "merchantId": "123",
+  "metadata": {"apiKey": "key"},
+  "nameMasking": "initials",
+  "idnRules": [{"action": "ignore", "exact": ["1111"]}]
+}}`},
+	}
+
+	want := &epay.Environment{
+		BillingURL:  "http://billing",
+		EpaySecret:  "mysecret",
+		MerchantID:  "123",
+		Metadata:    map[string]string{"apiKey": "key"},
+		NameMasking: epay.NameMaskingInitials,
+		IDNRules:    []epay.IDNRule{{Action: epay.IDNActionIgnore, Exact: []string{"1111"}}},
+	}
+
+	for _, c := range cases {
+		path := writeFile(t, c.file, c.content)
+
+		store, err := NewFileStore(path)
+		if err != nil {
+			t.Fatalf("unable to create store from %s due: %v", c.file, err)
+		}
+
+		got, err := store.Get(context.Background(), "default")
+		if err != nil {
+			t.Fatalf("unable to get environment from %s due: %v", c.file, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected environment from %s (-want +got): %s", c.file, diff)
+		}
+
+		if _, err := store.Get(context.Background(), "unknown"); err != epay.ErrEnvironmentNotFound {
+			t.Errorf("expected: %v, got: %v", epay.ErrEnvironmentNotFound, err)
+		}
+	}
+}
+
+func TestFileStoreWithInvalidRule(t *testing.T) {
+	path := writeFile(t, "envs.yaml", `
+default:
+  idnRules:
+    - action: unknown
+`)
+	if _, err := NewFileStore(path); err == nil {
+		t.Errorf("expected invalid IDN rule to not be accepted")
+	}
+}
+
+func TestFileStoreWatch(t *testing.T) {
+	path := writeFile(t, "envs.yaml", "default:\n  merchantId: \"1\"\n")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("unable to create store due: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Watch(ctx, 10*time.Millisecond)
+
+	if err := ioutil.WriteFile(path, []byte("default:\n  merchantId: \"2\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Second)
+	os.Chtimes(path, modTime, modTime)
+
+	for i := 0; i < 100; i++ {
+		env, _ := store.Get(ctx, "default")
+		if env.MerchantID == "2" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected environment to be reloaded after the file was modified")
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "envstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,95 @@
+package envstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// Schema is the schema of the table which is used by the SQL store.
+const Schema = `CREATE TABLE IF NOT EXISTS environments (
+	name VARCHAR(255) PRIMARY KEY,
+	config TEXT NOT NULL
+)`
+
+// NewSQLStore creates a new store which keeps the environments as JSON documents in
+// the environments table of the provided database. The driver is the name of the
+// database/sql driver which is used for choosing of the placeholders, e.g sqlite3 or postgres.
+func NewSQLStore(db *sql.DB, driver string) epay.EnvironmentRegistry {
+	return &sqlStore{db: db, postgres: strings.HasPrefix(driver, "postgres")}
+}
+
+type sqlStore struct {
+	db       *sql.DB
+	postgres bool
+}
+
+func (s *sqlStore) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	var config string
+	err := s.db.QueryRowContext(ctx, s.query("SELECT config FROM environments WHERE name = ?"), name).Scan(&config)
+	if err == sql.ErrNoRows {
+		return nil, epay.ErrEnvironmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load the environment '%s' due: %v", name, err)
+	}
+
+	d := &Document{}
+	if err := json.Unmarshal([]byte(config), d); err != nil {
+		return nil, fmt.Errorf("could not decode the environment '%s' due: %v", name, err)
+	}
+	return d.Environment(name)
+}
+
+func (s *sqlStore) List(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM environments ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("could not list environments due: %v", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (s *sqlStore) Put(ctx context.Context, name string, env *epay.Environment) error {
+	config, err := json.Marshal(NewDocument(env))
+	if err != nil {
+		return err
+	}
+
+	q := s.query("INSERT INTO environments (name, config) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET config = excluded.config")
+	if _, err := s.db.ExecContext(ctx, q, name, string(config)); err != nil {
+		return fmt.Errorf("could not store the environment '%s' due: %v", name, err)
+	}
+	return nil
+}
+
+// query replaces the ? placeholders with $n placeholders when postgres is used.
+func (s *sqlStore) query(q string) string {
+	if !s.postgres {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString(fmt.Sprintf("$%d", n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
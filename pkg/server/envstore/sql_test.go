@@ -0,0 +1,75 @@
+//go:build cgo
+// +build cgo
+
+package envstore
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/google/go-cmp/cmp"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("unable to open database due: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(Schema); err != nil {
+		t.Fatalf("unable to create schema due: %v", err)
+	}
+
+	ctx := context.Background()
+	store := NewSQLStore(db, "sqlite3")
+
+	if _, err := store.Get(ctx, "default"); err != epay.ErrEnvironmentNotFound {
+		t.Errorf("expected: %v, got: %v", epay.ErrEnvironmentNotFound, err)
+	}
+
+	env := &epay.Environment{
+		BillingJWTKey: "{}",
+		BillingKey:    "{}",
+		EpaySecret:    "mysecret",
+		EpaySecrets:   []epay.Secret{{ID: "new", Value: "newsecret"}},
+		MerchantID:    "123",
+		IDNMasking:    true,
+	}
+	if err := store.Put(ctx, "default", env); err != nil {
+		t.Fatalf("unable to put environment due: %v", err)
+	}
+	env.MerchantID = "456"
+	if err := store.Put(ctx, "default", env); err != nil {
+		t.Fatalf("unable to update environment due: %v", err)
+	}
+
+	got, err := store.Get(ctx, "default")
+	if err != nil {
+		t.Fatalf("unable to get environment due: %v", err)
+	}
+	if diff := cmp.Diff(env, got); diff != "" {
+		t.Errorf("unexpected environment (-want +got): %s", diff)
+	}
+
+	names, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("unable to list environments due: %v", err)
+	}
+	if diff := cmp.Diff([]string{"default"}, names); diff != "" {
+		t.Errorf("unexpected names (-want +got): %s", diff)
+	}
+}
+
+func TestPostgresPlaceholders(t *testing.T) {
+	s := &sqlStore{postgres: true}
+	got := s.query("INSERT INTO environments (name, config) VALUES (?, ?)")
+	want := "INSERT INTO environments (name, config) VALUES ($1, $2)"
+	if got != want {
+		t.Errorf("expected: %s, got: %s", want, got)
+	}
+}
@@ -2,8 +2,10 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -11,41 +13,51 @@ import (
 	"github.com/clouway/go-epay/pkg/server"
 	"github.com/clouway/go-epay/pkg/server/api"
 	"github.com/clouway/go-epay/pkg/server/httputil"
+	"github.com/clouway/go-epay/pkg/server/tenant"
 )
 
 // EpayAPIMiddleware is a middleware used to check the request using internal secret
-// stored in the environment.
-func EpayAPIMiddleware(envStore epay.EnvironmentStore) func(http.Handler) http.Handler {
+// stored in the environment of the tenant which is resolved by the provided resolver.
+func EpayAPIMiddleware(envStore epay.EnvironmentStore, resolver tenant.Resolver) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			contextLogger := log.WithContext(r.Context())
-			metadata := r.Header["X-Google-Apps-Metadata"]
-			host := ""
-			for _, m := range metadata {
-				if strings.Contains(m, ",") && strings.Contains(m, "=") {
-					parts := strings.Split(m, ",")
-					host = strings.Split(parts[1], "=")[1]
-				}
+
+			name, err := resolver.Resolve(r)
+			if err != nil {
+				contextLogger.Warnf("unable to resolve tenant due: %v", err)
+				httputil.RespondWithJSON(r.Context(), w, &api.ErrorResponse{Status: api.StatusCommonError, Error: err.Error()})
+				return
 			}
 
-			if host == "" {
-				host = r.URL.Host
+			env, err := envStore.Get(r.Context(), name)
+			if errors.Is(err, epay.ErrEnvironmentNotFound) {
+				contextLogger.Warnf("unknown tenant '%s'", name)
+				httputil.RespondWithJSON(r.Context(), w, &api.ErrorResponse{Status: api.StatusCommonError, Error: fmt.Sprintf("unknown tenant '%s'", name)})
+				return
 			}
-			env, err := envStore.Get(r.Context(), host)
 			if err != nil {
-				contextLogger.Debugf("unable to read environment due: %v", err)
-				http.Error(w, "unable to read env configuration", http.StatusInternalServerError)
+				contextLogger.Errorf("unable to read environment of tenant '%s' due: %v", name, err)
+				httputil.RespondWithJSON(r.Context(), w, &api.ErrorResponse{Status: api.StatusTemporaryNotAvailable, Error: "unable to read tenant configuration"})
 				return
 			}
 
-			checksum := r.URL.Query().Get("CHECKSUM")
+			req, err := api.ParseRequest(r)
+			if err != nil {
+				contextLogger.Debugf("unable to parse request due: %v", err)
+				httputil.RespondWithJSON(r.Context(), w, api.ErrBadChecksum)
+				return
+			}
 
-			if checksum != epay.Checksum(r.URL.Query(), env.EpaySecret) {
+			secret := epay.VerifyChecksum(req.Values, req.Checksum, env.ActiveSecrets(time.Now()))
+			if secret == nil {
 				httputil.RespondWithJSON(r.Context(), w, api.ErrBadChecksum)
 				return
 			}
+			contextLogger.Debugf("checksum was verified using secret '%s'", secret.ID)
 
 			nextCtx := context.WithValue(r.Context(), server.EnvironmentKey, env)
+			nextCtx = context.WithValue(nextCtx, server.TenantKey, name)
 			next.ServeHTTP(w, r.WithContext(nextCtx))
 		})
 	}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server"
+	"github.com/clouway/go-epay/pkg/server/api"
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+// IDNRules skips the next request processing if the requested IDN is matched by any
+// of the IDN rules of the environment. It should be used after EpayAPIMiddleware as
+// it relies on the resolved environment.
+func IDNRules(op epay.Operation) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			contextLogger := log.WithContext(ctx)
+
+			env, ok := server.EnvironmentFromContext(ctx)
+			if !ok {
+				contextLogger.Errorf("no environment is associated with the request")
+				httputil.RespondWithJSON(ctx, w, &api.DutyResponse{Status: api.StatusCommonError})
+				return
+			}
+			req, err := api.ParseRequest(r)
+			if err != nil {
+				contextLogger.Debugf("unable to parse request due: %v", err)
+				httputil.RespondWithJSON(ctx, w, api.DutyResponse{Status: api.StatusCommonError})
+				return
+			}
+			idn := req.IDN
+
+			rule := env.MatchIDNRule(op, idn)
+			if rule == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if rule.Action == epay.IDNActionDeny {
+				contextLogger.Warnf("Denying %s of '%s' as it is matched by IDN rule", op, env.DisplayIDN(idn))
+			} else {
+				contextLogger.Debugf("Skipping %s of '%s' as it is ignored", op, env.DisplayIDN(idn))
+			}
+			httputil.RespondWithJSON(ctx, w, api.DutyResponse{Status: rule.ResponseStatus()})
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/server/api"
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+// Recover is a middleware which recovers from panics of the next handler and
+// responds with a common error status.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				ctx := r.Context()
+				log.WithContext(ctx).Errorf("recovered from panic: %v\n%s", err, debug.Stack())
+				httputil.RespondWithJSON(ctx, w, &api.DutyResponse{Status: api.StatusCommonError})
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecoverFromPanic(t *testing.T) {
+	handler := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("missing environment")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/pay/init?TYPE=CHECK", nil))
+
+	if want := `{"STATUS":"96"}`; w.Body.String() != want {
+		t.Errorf("expected response: %s", want)
+		t.Errorf("          but got: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/server"
+	"github.com/clouway/go-epay/pkg/server/api"
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+// ReplayOptions are the options of the ReplayGuard.
+type ReplayOptions struct {
+	// TTL is the time for which processed requests are remembered.
+	TTL time.Duration
+
+	// TimestampParam is the name of the optional request parameter which holds the time
+	// of the request as unix seconds or RFC3339 value.
+	TimestampParam string
+
+	// MaxSkew is the maximum allowed difference between the time of the request
+	// and the current time. Timestamp is not checked when it's zero.
+	MaxSkew time.Duration
+}
+
+// ReplayGuard is a middleware which protects from replaying of signed requests. It
+// remembers the (endpoint, TYPE, TID) tuple of every request and returns the original
+// response when the same request is received again, without calling the next handler.
+// Only final responses are remembered, so requests which failed temporary are processed
+// again, and CHECK requests are not guarded as their amount is changing.
+// It should be used after EpayAPIMiddleware as it relies on the resolved environment.
+func ReplayGuard(store server.ReplayStore, opts ReplayOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			contextLogger := log.WithContext(ctx)
+
+			req, err := api.ParseRequest(r)
+			if err != nil {
+				contextLogger.Debugf("unable to parse request due: %v", err)
+				httputil.RespondWithJSON(ctx, w, api.ErrBadChecksum)
+				return
+			}
+
+			if opts.MaxSkew > 0 && opts.TimestampParam != "" {
+				if ts := req.Values.Get(opts.TimestampParam); ts != "" && !withinSkew(ts, time.Now(), opts.MaxSkew) {
+					contextLogger.Warnf("request timestamp '%s' is out of the allowed skew", ts)
+					httputil.RespondWithJSON(ctx, w, api.ErrBadChecksum)
+					return
+				}
+			}
+
+			if req.Type == string(checkRequest) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			env, ok := server.EnvironmentFromContext(ctx)
+			if !ok {
+				contextLogger.Errorf("no environment is associated with the request")
+				httputil.RespondWithJSON(ctx, w, &api.DutyResponse{Status: api.StatusCommonError})
+				return
+			}
+			// The last path segment is used as the same endpoint could be reached with and without
+			// a tenant prefix. The checksum is not part of the key as it's accepted in any case.
+			key := replayKey(server.TenantFromContext(ctx), env.MerchantID, path.Base(r.URL.Path), req.Type, req.TID)
+
+			reserved, resp, err := store.Reserve(ctx, key, opts.TTL)
+			if err != nil {
+				contextLogger.Errorf("unable to check for replayed request due: %v", err)
+				httputil.RespondWithJSON(ctx, w, &api.DutyResponse{Status: api.StatusTemporaryNotAvailable})
+				return
+			}
+
+			if !reserved {
+				if resp == nil {
+					contextLogger.Warnf("request with TID '%s' is still in processing", req.TID)
+					httputil.RespondWithJSON(ctx, w, &api.DutyResponse{Status: api.StatusTemporaryNotAvailable})
+					return
+				}
+				contextLogger.Warnf("request with TID '%s' was replayed, returning the original response", req.TID)
+				w.Header().Set("Content-Type", resp.ContentType)
+				w.WriteHeader(resp.StatusCode)
+				w.Write(resp.Body)
+				return
+			}
+
+			// Requests which were not handled, failed temporary or panicked are released, so they could be retried.
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				if err := store.Release(ctx, key); err != nil {
+					contextLogger.Errorf("unable to release request due: %v", err)
+				}
+			}()
+
+			rec := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			if rec.statusCode >= 300 || !finalResponse(rec.body.Bytes()) {
+				return
+			}
+			completed = true
+
+			err = store.Complete(ctx, key, server.ReplayResponse{
+				StatusCode:  rec.statusCode,
+				ContentType: rec.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			}, opts.TTL)
+			if err != nil {
+				contextLogger.Errorf("unable to store response of request due: %v", err)
+			}
+		})
+	}
+}
+
+// checkRequest is the type of the requests which check the duties of the subscriber.
+const checkRequest = "CHECK"
+
+// finalResponse checks whether the provided response has a status which is not changed when
+// the request is processed again. Failures such as unavailable billing are not final.
+func finalResponse(body []byte) bool {
+	var resp api.DutyResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false
+	}
+	switch resp.Status {
+	case api.StatusSuccess, api.StatusSubscriberNotFound, api.StatusNoDuties, api.StatusAlreadyPaid:
+		return true
+	}
+	return false
+}
+
+func replayKey(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(h[:])
+}
+
+func withinSkew(ts string, now time.Time, maxSkew time.Duration) bool {
+	var t time.Time
+	if sec, err := strconv.ParseInt(ts, 10, 64); err == nil {
+		t = time.Unix(sec, 0)
+	} else if parsed, err := time.Parse(time.RFC3339, ts); err == nil {
+		t = parsed
+	} else {
+		return false
+	}
+
+	skew := now.Sub(t)
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew <= maxSkew
+}
+
+// responseRecorder is a ResponseWriter which records the written response.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server"
+)
+
+func TestReplayedRequestGetsOriginalResponse(t *testing.T) {
+	calls := 0
+	handler := ReplayGuard(server.NewMemoryReplayStore(), ReplayOptions{TTL: time.Hour})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"STATUS":"00","CALL":` + strconv.Itoa(calls) + `}`))
+	}))
+
+	target := "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=abc"
+	first := serve(handler, target)
+	replayed := serve(handler, target)
+
+	if calls != 1 {
+		t.Errorf("expected handler to be called once, but was called %d times", calls)
+	}
+	if want := `{"STATUS":"00","CALL":1}`; first.Body.String() != want || replayed.Body.String() != want {
+		t.Errorf("expected both responses to be: %s", want)
+		t.Errorf("                      but got: %s and %s", first.Body.String(), replayed.Body.String())
+	}
+	if ct := replayed.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected content type of replayed response to be application/json, but got: %s", ct)
+	}
+}
+
+func TestDifferentRequestsAreNotReplayed(t *testing.T) {
+	calls := 0
+	handler := ReplayGuard(server.NewMemoryReplayStore(), ReplayOptions{TTL: time.Hour})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	serve(handler, "/v1/pay/init?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=abc")
+	serve(handler, "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=abc")
+	serve(handler, "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T2&CHECKSUM=abd")
+
+	if calls != 3 {
+		t.Errorf("expected handler to be called 3 times, but was called %d times", calls)
+	}
+}
+
+func TestRequestReplayedWithTenantPrefix(t *testing.T) {
+	calls := 0
+	handler := ReplayGuard(server.NewMemoryReplayStore(), ReplayOptions{TTL: time.Hour})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"STATUS":"00"}`))
+	}))
+
+	serve(handler, "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=abc")
+	serve(handler, "/t/acme/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=abc")
+
+	if calls != 1 {
+		t.Errorf("expected handler to be called once, but was called %d times", calls)
+	}
+}
+
+func TestFailedRequestIsReleased(t *testing.T) {
+	calls := 0
+	handler := ReplayGuard(server.NewMemoryReplayStore(), ReplayOptions{TTL: time.Hour})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "unable to read env configuration", http.StatusInternalServerError)
+	}))
+
+	target := "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=abc"
+	serve(handler, target)
+	serve(handler, target)
+
+	if calls != 2 {
+		t.Errorf("expected handler to be called twice, but was called %d times", calls)
+	}
+}
+
+func TestRequestReplayedWithChecksumInOtherCase(t *testing.T) {
+	calls := 0
+	handler := ReplayGuard(server.NewMemoryReplayStore(), ReplayOptions{TTL: time.Hour})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"STATUS":"00"}`))
+	}))
+
+	serve(handler, "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=abc")
+	serve(handler, "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=ABC")
+
+	if calls != 1 {
+		t.Errorf("expected handler to be called once, but was called %d times", calls)
+	}
+}
+
+func TestTemporaryFailureIsReleased(t *testing.T) {
+	cases := []struct {
+		name   string
+		status string
+		calls  int
+	}{
+		{"success", "00", 1},
+		{"subscriber not found", "14", 1},
+		{"no duties", "62", 1},
+		{"already paid", "94", 1},
+		{"temporary not available", "80", 2},
+		{"common error", "96", 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			calls := 0
+			handler := ReplayGuard(server.NewMemoryReplayStore(), ReplayOptions{TTL: time.Hour})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Write([]byte(`{"STATUS":"` + c.status + `"}`))
+			}))
+
+			target := "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=abc"
+			serve(handler, target)
+			serve(handler, target)
+
+			if calls != c.calls {
+				t.Errorf("expected handler to be called %d times, but was called %d times", c.calls, calls)
+			}
+		})
+	}
+}
+
+func TestCheckRequestIsNotReplayed(t *testing.T) {
+	calls := 0
+	handler := ReplayGuard(server.NewMemoryReplayStore(), ReplayOptions{TTL: time.Hour})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"STATUS":"00","AMOUNT":` + strconv.Itoa(calls) + `}`))
+	}))
+
+	target := "/v1/pay/init?TYPE=CHECK&IDN=123&TID=T1&CHECKSUM=abc"
+	serve(handler, target)
+	if resp := serve(handler, target); resp.Body.String() != `{"STATUS":"00","AMOUNT":2}` {
+		t.Errorf("expected current amount, but got: %s", resp.Body.String())
+	}
+}
+
+func TestPanickedRequestIsReleased(t *testing.T) {
+	calls := 0
+	handler := Recover(ReplayGuard(server.NewMemoryReplayStore(), ReplayOptions{TTL: time.Hour})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		w.Write([]byte(`{"STATUS":"00"}`))
+	})))
+
+	target := "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=abc"
+	serve(handler, target)
+	if resp := serve(handler, target); resp.Body.String() != `{"STATUS":"00"}` {
+		t.Errorf("expected retried request to be processed, but got: %s", resp.Body.String())
+	}
+}
+
+func TestRequestWithSkewedTimestamp(t *testing.T) {
+	called := false
+	handler := ReplayGuard(server.NewMemoryReplayStore(), ReplayOptions{TTL: time.Hour, TimestampParam: "TIMESTAMP", MaxSkew: time.Minute})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	ts := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	resp := serve(handler, "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=T1&CHECKSUM=abc&TIMESTAMP="+ts)
+
+	if called {
+		t.Errorf("expected request with skewed timestamp to be rejected")
+	}
+	if want := `{"STATUS":"93"}`; resp.Body.String() != want {
+		t.Errorf("expected response: %s", want)
+		t.Errorf("          but got: %s", resp.Body.String())
+	}
+}
+
+func serve(h http.Handler, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	r = r.WithContext(context.WithValue(r.Context(), server.EnvironmentKey, &epay.Environment{MerchantID: "M1"}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
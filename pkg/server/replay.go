@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReplayResponse is the response of an already processed request which is
+// returned back when request is replayed.
+type ReplayResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// ReplayStore is a TTL store of the responses of processed requests.
+type ReplayStore interface {
+	// Reserve reserves the provided key for processing for the provided ttl. The response of
+	// the request is returned when key was already reserved and nil response means that
+	// request is still in processing.
+	Reserve(ctx context.Context, key string, ttl time.Duration) (reserved bool, resp *ReplayResponse, err error)
+
+	// Complete stores the response of the request associated with the provided key.
+	Complete(ctx context.Context, key string, resp ReplayResponse, ttl time.Duration) error
+
+	// Release releases the reservation of key, so request could be processed again.
+	Release(ctx context.Context, key string) error
+}
+
+// NewMemoryReplayStore creates a new ReplayStore which keeps the responses in memory. It's
+// suitable only for deployments with a single instance.
+func NewMemoryReplayStore() ReplayStore {
+	return &memoryReplayStore{entries: make(map[string]replayEntry)}
+}
+
+type replayEntry struct {
+	resp      *ReplayResponse
+	expiresAt time.Time
+}
+
+type memoryReplayStore struct {
+	mu        sync.Mutex
+	entries   map[string]replayEntry
+	lastPurge time.Time
+}
+
+func (m *memoryReplayStore) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, *ReplayResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.purge(now)
+
+	if e, ok := m.entries[key]; ok && now.Before(e.expiresAt) {
+		return false, e.resp, nil
+	}
+	m.entries[key] = replayEntry{expiresAt: now.Add(ttl)}
+	return true, nil, nil
+}
+
+func (m *memoryReplayStore) Complete(ctx context.Context, key string, resp ReplayResponse, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = replayEntry{resp: &resp, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryReplayStore) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memoryReplayStore) purge(now time.Time) {
+	if now.Sub(m.lastPurge) < time.Minute {
+		return
+	}
+	m.lastPurge = now
+
+	for k, e := range m.entries {
+		if now.After(e.expiresAt) {
+			delete(m.entries, k)
+		}
+	}
+}
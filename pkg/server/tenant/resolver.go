@@ -0,0 +1,143 @@
+package tenant
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ErrNotResolved is the error returned by resolvers when the tenant could not
+// be resolved from the request.
+var ErrNotResolved = errors.New("tenant could not be resolved")
+
+// Resolver resolves the tenant of the received request. The resolved tenant is the
+// name of the environment which is used for processing of the request.
+type Resolver interface {
+	// Resolve resolves the tenant of the provided request. ErrNotResolved is returned
+	// when request is not carrying the tenant.
+	Resolve(r *http.Request) (string, error)
+}
+
+// ResolverFunc is an adapter which allows ordinary functions to be used as resolvers.
+type ResolverFunc func(r *http.Request) (string, error)
+
+// Resolve calls f(r).
+func (f ResolverFunc) Resolve(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// Chain creates a resolver which tries each of the provided resolvers in order and
+// returns the first resolved tenant.
+func Chain(resolvers ...Resolver) Resolver {
+	return ResolverFunc(func(r *http.Request) (string, error) {
+		for _, resolver := range resolvers {
+			name, err := resolver.Resolve(r)
+			if err == ErrNotResolved {
+				continue
+			}
+			return name, err
+		}
+		return "", ErrNotResolved
+	})
+}
+
+// Static creates a resolver which always resolves the provided tenant. It's useful
+// for single-tenant setups and as a fallback at the end of a chain.
+func Static(name string) Resolver {
+	return ResolverFunc(func(r *http.Request) (string, error) {
+		return name, nil
+	})
+}
+
+// ByHost creates a resolver which uses the Host header of the request as tenant. The
+// aliases are used for mapping of hosts and host suffixes to tenants, e.g appspot.com to
+// default, where the longest matching suffix wins, while hosts which are not mapped are
+// resolved as they are.
+func ByHost(aliases map[string]string) Resolver {
+	suffixes := make([]string, 0, len(aliases))
+	for suffix := range aliases {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Slice(suffixes, func(i, j int) bool {
+		if len(suffixes[i]) != len(suffixes[j]) {
+			return len(suffixes[i]) > len(suffixes[j])
+		}
+		return suffixes[i] < suffixes[j]
+	})
+
+	return ResolverFunc(func(r *http.Request) (string, error) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			return "", ErrNotResolved
+		}
+
+		for _, suffix := range suffixes {
+			if host == suffix || strings.HasSuffix(host, "."+suffix) {
+				return aliases[suffix], nil
+			}
+		}
+		return host, nil
+	})
+}
+
+// ByParam creates a resolver which uses the value of the provided request parameter, e.g
+// MERCHANTID, as tenant. The parameter is read from the query string or the form-encoded body.
+func ByParam(name string) Resolver {
+	return ResolverFunc(func(r *http.Request) (string, error) {
+		if v := r.FormValue(name); v != "" {
+			return v, nil
+		}
+		return "", ErrNotResolved
+	})
+}
+
+// ByPathPrefix creates a resolver which uses the path segment after the provided
+// prefix as tenant, e.g /t/{tenant}/v1/pay/init when prefix is /t/.
+func ByPathPrefix(prefix string) Resolver {
+	return ResolverFunc(func(r *http.Request) (string, error) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			return "", ErrNotResolved
+		}
+		rest := strings.TrimPrefix(r.URL.Path, prefix)
+		name := strings.SplitN(rest, "/", 2)[0]
+		if name == "" {
+			return "", fmt.Errorf("no tenant is provided in path '%s'", r.URL.Path)
+		}
+		return name, nil
+	})
+}
+
+// ByHeader creates a resolver which uses the value of the provided header as tenant.
+func ByHeader(header string) Resolver {
+	return ResolverFunc(func(r *http.Request) (string, error) {
+		if v := strings.TrimSpace(r.Header.Get(header)); v != "" {
+			return v, nil
+		}
+		return "", ErrNotResolved
+	})
+}
+
+// ByAppEngineMetadata creates a resolver which uses the domain from the X-Google-Apps-Metadata
+// header that is set by App Engine for requests to custom domains. The header could be set by
+// any client when the service is not running on App Engine, so it should be used only there.
+func ByAppEngineMetadata() Resolver {
+	return ResolverFunc(func(r *http.Request) (string, error) {
+		for _, m := range r.Header["X-Google-Apps-Metadata"] {
+			pairs := strings.Split(m, ",")
+			if len(pairs) < 2 {
+				continue
+			}
+			kv := strings.SplitN(pairs[1], "=", 2)
+			if len(kv) == 2 && kv[1] != "" {
+				return kv[1], nil
+			}
+		}
+		return "", ErrNotResolved
+	})
+}
@@ -0,0 +1,50 @@
+package tenant
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	cases := []struct {
+		name     string
+		resolver Resolver
+		target   string
+		host     string
+		headers  map[string]string
+		want     string
+		wantErr  error
+	}{
+		{"by host", ByHost(map[string]string{"epay.example.com": "acme"}), "/v1/pay/init", "epay.example.com:8080", nil, "acme", nil},
+		{"by not mapped host", ByHost(map[string]string{"epay.example.com": "acme"}), "/v1/pay/init", "other.example.com", nil, "other.example.com", nil},
+		{"by longest host suffix", ByHost(map[string]string{"example.com": "default", "epay.example.com": "acme"}), "/v1/pay/init", "a.epay.example.com", nil, "acme", nil},
+		{"by host alias", ByHost(map[string]string{"appspot.com": "default"}), "/v1/pay/init", "myapp.appspot.com", nil, "default", nil},
+		{"by param", ByParam("MERCHANTID"), "/v1/pay/init?MERCHANTID=M1", "", nil, "M1", nil},
+		{"by missing param", ByParam("MERCHANTID"), "/v1/pay/init", "", nil, "", ErrNotResolved},
+		{"by path prefix", ByPathPrefix("/t/"), "/t/acme/v1/pay/init", "", nil, "acme", nil},
+		{"by not matching path prefix", ByPathPrefix("/t/"), "/v1/pay/init", "", nil, "", ErrNotResolved},
+		{"by header", ByHeader("X-Epay-Tenant"), "/v1/pay/init", "", map[string]string{"X-Epay-Tenant": "acme"}, "acme", nil},
+		{"by app engine metadata", ByAppEngineMetadata(), "/v1/pay/init", "", map[string]string{"X-Google-Apps-Metadata": "domain=example.com,host=epay.example.com"}, "epay.example.com", nil},
+		{"chain", Chain(ByPathPrefix("/t/"), ByHeader("X-Epay-Tenant"), ByHost(nil)), "/v1/pay/init", "epay.example.com", map[string]string{"X-Epay-Tenant": "acme"}, "acme", nil},
+		{"chain falls back to host", Chain(ByPathPrefix("/t/"), ByHost(nil)), "/v1/pay/init", "epay.example.com", map[string]string{"X-Epay-Tenant": "acme"}, "epay.example.com", nil},
+		{"chain not resolved", Chain(ByPathPrefix("/t/"), ByHeader("X-Epay-Tenant")), "/v1/pay/init", "", nil, "", ErrNotResolved},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", c.target, nil)
+			r.Host = c.host
+			for k, v := range c.headers {
+				r.Header.Set(k, v)
+			}
+
+			got, err := c.resolver.Resolve(r)
+			if err != c.wantErr {
+				t.Fatalf("Resolve() error = %v, want: %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("Resolve() = %s, want: %s", got, c.want)
+			}
+		})
+	}
+}